@@ -0,0 +1,418 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	WorkspaceInventory = "workspace"
+
+	JobEndpoint       = "job"
+	WorkflowEndpoint  = "workflow"
+	InventoryEndpoint = "inventory"
+	AdHocEndpoint     = "adhoc"
+)
+
+var actionsFile string
+var actions map[string]ActionConfig
+
+type ActionsConfig struct {
+	Actions map[string]ActionConfig `yaml:"actions"`
+}
+
+// ActionConfig holds the options for a named action, selected by adding
+// ?action=<name> to the Run Task URL. Endpoint ties the action to one
+// endpoint, so that options it cannot use are rejected on startup
+type ActionConfig struct {
+	Endpoint     string            `yaml:"endpoint,omitempty"`
+	Organization int               `yaml:"organization,omitempty"`
+	Message      string            `yaml:"message,omitempty"`
+	Launch       LaunchConfig      `yaml:"launch,omitempty"`
+	Survey       map[string]string `yaml:"survey,omitempty"`
+	Inventory    InventoryConfig   `yaml:"inventory,omitempty"`
+	Destroy      DestroyConfig     `yaml:"destroy,omitempty"`
+	Target       TargetConfig      `yaml:"target,omitempty"`
+	ProjectSync  ProjectSyncConfig `yaml:"project_sync,omitempty"`
+	AdHoc        AdHocConfig       `yaml:"adhoc,omitempty"`
+	Speculative  SpeculativeConfig `yaml:"speculative,omitempty"`
+	Concurrency  ConcurrencyConfig `yaml:"concurrency,omitempty"`
+	Gate         GateConfig        `yaml:"gate,omitempty"`
+	Wait         bool              `yaml:"wait,omitempty"`
+	Artifacts    []string          `yaml:"artifacts,omitempty"`
+}
+
+// LaunchConfig sets prompt-on-launch fields for Job and Workflow Job
+// Templates. String values are templates, or begin with $ to take the Run
+// Task payload field of that name e.g. $vcs_branch
+type LaunchConfig struct {
+	Inventory            string         `yaml:"inventory,omitempty"`
+	Limit                string         `yaml:"limit,omitempty"`
+	ScmBranch            string         `yaml:"scm_branch,omitempty"`
+	JobTags              string         `yaml:"job_tags,omitempty"`
+	SkipTags             string         `yaml:"skip_tags,omitempty"`
+	JobType              string         `yaml:"job_type,omitempty"`
+	Verbosity            *int           `yaml:"verbosity,omitempty"`
+	DiffMode             *bool          `yaml:"diff_mode,omitempty"`
+	Credentials          []int          `yaml:"credentials,omitempty"`
+	ExecutionEnvironment int            `yaml:"execution_environment,omitempty"`
+	InstanceGroups       []int          `yaml:"instance_groups,omitempty"`
+	Labels               []int          `yaml:"labels,omitempty"`
+	ExtraVars            map[string]any `yaml:"extra_vars,omitempty"`
+
+	// literal and literalExtraVars hold launch values ARTs works out itself,
+	// which are sent as they are rather than rendered as templates
+	literal          map[string]string
+	literalExtraVars map[string]any
+}
+
+// setLiteral sets a launch string, such as scm_branch, to a value that is not
+// rendered as a template. The action is shared between requests, so the map
+// is copied rather than changed
+func (l *LaunchConfig) setLiteral(name string, value string) {
+	literal := make(map[string]string, len(l.literal)+1)
+	for key, existing := range l.literal {
+		literal[key] = existing
+	}
+	literal[name] = value
+	l.literal = literal
+}
+
+// setLiteralExtraVar sets an extra var to a value that is not rendered as a
+// template, replacing any configured extra var of the same name
+func (l *LaunchConfig) setLiteralExtraVar(name string, value any) {
+	if _, ok := l.ExtraVars[name]; ok {
+		extraVars := make(map[string]any, len(l.ExtraVars))
+		for key, configured := range l.ExtraVars {
+			if key != name {
+				extraVars[key] = configured
+			}
+		}
+		l.ExtraVars = extraVars
+	}
+	l.literalExtraVars = mergeExtraVars(l.literalExtraVars, map[string]any{name: value})
+}
+
+// InventoryConfig sets the templated name and description of the inventory
+// created by the inventory endpoint, and the state output that post-apply
+// Run Tasks synchronise it from. Kind creates a smart inventory from a host
+// filter, or a constructed inventory from existing input inventories
+type InventoryConfig struct {
+	Name        string `yaml:"name,omitempty"`
+	Description string `yaml:"description,omitempty"`
+	Output      string `yaml:"output,omitempty"`
+
+	Variables InventoryVariablesConfig `yaml:"variables,omitempty"`
+	GroupTree GroupTreeConfig          `yaml:"group_tree,omitempty"`
+	Sources   []InventorySourceConfig  `yaml:"sources,omitempty"`
+
+	// smart and constructed inventories
+	Kind             string           `yaml:"kind,omitempty"`
+	HostFilter       string           `yaml:"host_filter,omitempty"`
+	InputInventories []string         `yaml:"input_inventories,omitempty"`
+	SourceVars       map[string]any   `yaml:"source_vars,omitempty"`
+	Limit            string           `yaml:"limit,omitempty"`
+	Groups           map[string]any   `yaml:"groups,omitempty"`
+	KeyedGroups      []map[string]any `yaml:"keyed_groups,omitempty"`
+}
+
+func loadActions(path string) (map[string]ActionConfig, error) {
+	if len(path) == 0 {
+		return map[string]ActionConfig{}, nil
+	}
+
+	data, readErr := os.ReadFile(path)
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	var config ActionsConfig
+	if yamlErr := yaml.Unmarshal(data, &config); yamlErr != nil {
+		return nil, fmt.Errorf("unable to parse actions file %s: %s", path, yamlErr)
+	}
+
+	if config.Actions == nil {
+		config.Actions = map[string]ActionConfig{}
+	}
+
+	for name, action := range config.Actions {
+		switch action.Endpoint {
+		case "", JobEndpoint, WorkflowEndpoint, InventoryEndpoint, AdHocEndpoint:
+		default:
+			return nil, fmt.Errorf("action %s: endpoint must be %s, %s, %s or %s, not %q", name, JobEndpoint, WorkflowEndpoint, InventoryEndpoint, AdHocEndpoint, action.Endpoint)
+		}
+		if inventoryErr := checkInventoryConfig(action.Inventory); inventoryErr != nil {
+			return nil, fmt.Errorf("action %s: %s", name, inventoryErr)
+		}
+		if syncErr := checkProjectSyncConfig(action.ProjectSync); syncErr != nil {
+			return nil, fmt.Errorf("action %s: %s", name, syncErr)
+		}
+		if speculativeErr := checkSpeculativeConfig(action.Speculative, action.Endpoint); speculativeErr != nil {
+			return nil, fmt.Errorf("action %s: %s", name, speculativeErr)
+		}
+		if concurrencyErr := checkConcurrencyConfig(action.Concurrency); concurrencyErr != nil {
+			return nil, fmt.Errorf("action %s: %s", name, concurrencyErr)
+		}
+		if targetErr := checkTargetConfig(action.Target, action.Launch); targetErr != nil {
+			return nil, fmt.Errorf("action %s: %s", name, targetErr)
+		}
+		if len(action.Artifacts) > 0 && !action.Wait && !action.Gate.Enabled {
+			return nil, fmt.Errorf("action %s: artifacts are only available when the action waits for its job", name)
+		}
+		if len(action.Artifacts) > 0 && action.Endpoint == WorkflowEndpoint {
+			return nil, fmt.Errorf("action %s: artifacts are only available from Job Templates, not the %s endpoint", name, WorkflowEndpoint)
+		}
+		switch action.Destroy.Inventory {
+		case "", DestroyDeleteInventory, DestroyArchiveInventory:
+		default:
+			return nil, fmt.Errorf("action %s: destroy inventory must be %s or %s, not %q", name, DestroyDeleteInventory, DestroyArchiveInventory, action.Destroy.Inventory)
+		}
+	}
+
+	return config.Actions, nil
+}
+
+// actionForRequest returns the action named in the Run Task URL, or an empty
+// action if none was named
+func actionForRequest(c *gin.Context, endpoint string) (ActionConfig, error) {
+	name := c.Query("action")
+	if len(name) == 0 {
+		return ActionConfig{}, nil
+	}
+
+	action, ok := actions[name]
+	if !ok {
+		return ActionConfig{}, fmt.Errorf("unknown action %q", name)
+	}
+	if len(action.Endpoint) > 0 && action.Endpoint != endpoint {
+		return ActionConfig{}, fmt.Errorf("action %q can only be used with the %s endpoint", name, action.Endpoint)
+	}
+
+	return action, nil
+}
+
+// resolvePayloadValue returns value unchanged, unless it names a Run Task
+// payload field as $field_name, in which case that field's value is returned
+func resolvePayloadValue(value string, request RunTaskRequest) (string, error) {
+	if !strings.HasPrefix(value, "$") {
+		return value, nil
+	}
+	if strings.HasPrefix(value, "$$") {
+		return value[1:], nil
+	}
+
+	jsonRequest, jsonErr := json.Marshal(request)
+	if jsonErr != nil {
+		return "", jsonErr
+	}
+
+	var fields map[string]any
+	if jsonErr := json.Unmarshal(jsonRequest, &fields); jsonErr != nil {
+		return "", jsonErr
+	}
+
+	field := strings.TrimPrefix(value, "$")
+	if field == "access_token" {
+		return "", fmt.Errorf("the Run Task access token cannot be used as a launch value")
+	}
+
+	fieldValue, ok := fields[field]
+	if !ok || fieldValue == nil {
+		return "", nil
+	}
+
+	return fmt.Sprint(fieldValue), nil
+}
+
+// resolveActionValue renders a configured value, which is either a template
+// or a $field_name reference to the Run Task payload
+func resolveActionValue(name string, value string, data *TemplateData) (string, error) {
+	if strings.HasPrefix(value, "$") {
+		return resolvePayloadValue(value, data.request)
+	}
+	return renderTemplate(name, value, data)
+}
+
+// resolveInventory turns a configured inventory into an inventory ID. The
+// inventory may be an ID, or "workspace" for the inventory named after the
+// Terraform Workspace (as created by the inventory endpoint)
+func resolveInventory(inventory string, data *TemplateData, action ActionConfig, ansibleAuth *AnsibleAuthResponse) (int, error) {
+	resolved, resolveErr := resolveActionValue("inventory", inventory, data)
+	if resolveErr != nil {
+		return 0, resolveErr
+	}
+	return lookupInventory(resolved, data, action, ansibleAuth)
+}
+
+// lookupInventory turns an inventory ID, or "workspace", into an inventory ID
+// without rendering it as a template
+func lookupInventory(resolved string, data *TemplateData, action ActionConfig, ansibleAuth *AnsibleAuthResponse) (int, error) {
+	if len(resolved) == 0 {
+		return 0, nil
+	}
+
+	if resolved != WorkspaceInventory {
+		id, convErr := strconv.Atoi(resolved)
+		if convErr != nil {
+			return 0, fmt.Errorf("inventory must be an ID or %q, not %q", WorkspaceInventory, resolved)
+		}
+		return id, nil
+	}
+
+	name, nameErr := inventoryName(data, action)
+	if nameErr != nil {
+		return 0, nameErr
+	}
+
+	workspaceInventory, lookupErr := ansibleFindInventory(name, action.Organization, ansibleAuth)
+	if lookupErr != nil {
+		return 0, lookupErr
+	}
+	if workspaceInventory == nil {
+		return 0, fmt.Errorf("no Ansible Inventory found for Workspace %s", data.WorkspaceName)
+	}
+
+	return workspaceInventory.ID, nil
+}
+
+// inventoryName renders the name of the Workspace's inventory, which defaults
+// to the Workspace name
+func inventoryName(data *TemplateData, action ActionConfig) (string, error) {
+	if len(action.Inventory.Name) == 0 {
+		return data.WorkspaceName, nil
+	}
+	return resolveActionValue("inventory name", action.Inventory.Name, data)
+}
+
+func resolveLaunchStrings(data *TemplateData, values map[string]*string, literal map[string]string) error {
+	for name, value := range values {
+		if set, ok := literal[name]; ok {
+			*value = set
+			continue
+		}
+		resolved, err := resolveActionValue(name, *value, data)
+		if err != nil {
+			return err
+		}
+		*value = resolved
+	}
+	return nil
+}
+
+func buildJobTemplateRequest(data *TemplateData, action ActionConfig, ansibleAuth *AnsibleAuthResponse) (*AnsibleJobTemplateRequest, error) {
+	launch := action.Launch
+
+	inventory, invErr := resolveInventory(launch.Inventory, data, action, ansibleAuth)
+	if invErr != nil {
+		return nil, invErr
+	}
+
+	extraVars, varsErr := renderExtraVars(launch.ExtraVars, data)
+	if varsErr != nil {
+		return nil, varsErr
+	}
+	extraVars = mergeExtraVars(extraVars, launch.literalExtraVars)
+
+	jtReq := AnsibleJobTemplateRequest{
+		Inventory:            inventory,
+		ExtraVars:            extraVars,
+		Limit:                launch.Limit,
+		ScmBranch:            launch.ScmBranch,
+		JobTags:              launch.JobTags,
+		SkipTags:             launch.SkipTags,
+		JobType:              launch.JobType,
+		Verbosity:            launch.Verbosity,
+		DiffMode:             launch.DiffMode,
+		Credentials:          launch.Credentials,
+		ExecutionEnvironment: launch.ExecutionEnvironment,
+		InstanceGroups:       launch.InstanceGroups,
+		Labels:               launch.Labels,
+	}
+
+	launchStrings := map[string]*string{
+		"limit":      &jtReq.Limit,
+		"scm_branch": &jtReq.ScmBranch,
+		"job_tags":   &jtReq.JobTags,
+		"skip_tags":  &jtReq.SkipTags,
+	}
+	if err := resolveLaunchStrings(data, launchStrings, launch.literal); err != nil {
+		return nil, err
+	}
+
+	return &jtReq, nil
+}
+
+func buildWorkflowJobTemplateRequest(data *TemplateData, action ActionConfig, ansibleAuth *AnsibleAuthResponse) (*AnsibleWorkflowJobTemplateRequest, error) {
+	launch := action.Launch
+
+	// Workflow Job Templates do not prompt for these on launch
+	if len(launch.JobType) > 0 || launch.Verbosity != nil || launch.DiffMode != nil || len(launch.Credentials) > 0 || launch.ExecutionEnvironment != 0 || len(launch.InstanceGroups) > 0 {
+		return nil, fmt.Errorf("job_type, verbosity, diff_mode, credentials, execution_environment and instance_groups cannot be set when launching a Workflow Job Template")
+	}
+	if action.ProjectSync.Enabled {
+		return nil, fmt.Errorf("project_sync can only be used when launching a Job Template")
+	}
+	if action.Gate.Enabled {
+		return nil, fmt.Errorf("gate can only be used when launching a Job Template")
+	}
+
+	inventory, invErr := resolveInventory(launch.Inventory, data, action, ansibleAuth)
+	if invErr != nil {
+		return nil, invErr
+	}
+
+	extraVars, varsErr := renderExtraVars(launch.ExtraVars, data)
+	if varsErr != nil {
+		return nil, varsErr
+	}
+	extraVars = mergeExtraVars(extraVars, launch.literalExtraVars)
+
+	wfjtReq := AnsibleWorkflowJobTemplateRequest{
+		Inventory: inventory,
+		ExtraVars: extraVars,
+		Limit:     launch.Limit,
+		ScmBranch: launch.ScmBranch,
+		JobTags:   launch.JobTags,
+		SkipTags:  launch.SkipTags,
+		Labels:    launch.Labels,
+	}
+
+	launchStrings := map[string]*string{
+		"limit":      &wfjtReq.Limit,
+		"scm_branch": &wfjtReq.ScmBranch,
+		"job_tags":   &wfjtReq.JobTags,
+		"skip_tags":  &wfjtReq.SkipTags,
+	}
+	if err := resolveLaunchStrings(data, launchStrings, launch.literal); err != nil {
+		return nil, err
+	}
+
+	return &wfjtReq, nil
+}
+
+// actionRunTaskResponse creates the final Run Task response for an action,
+// rendering the action's message template if it has one. The template can use
+// .Status and .Message for the result ARTS would otherwise report
+func actionRunTaskResponse(action ActionConfig, request RunTaskRequest, status string, message string, detailsUrl string) *RunTaskResponse {
+	if len(action.Message) > 0 {
+		data := newTemplateData(request)
+		data.Status = status
+		data.Message = message
+
+		rendered, renderErr := renderTemplate("message", action.Message, data)
+		if renderErr != nil {
+			status = Failed
+			message = renderErr.Error()
+		} else {
+			message = rendered
+		}
+	}
+
+	return createRunTaskResponse(status, message, detailsUrl)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadTestActions loads an actions file with the given contents
+func loadTestActions(t *testing.T, contents string) (map[string]ActionConfig, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "actions.yml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return loadActions(path)
+}
+
+func TestLoadActionsRejectsWorkflowArtifacts(t *testing.T) {
+	_, err := loadTestActions(t, `
+actions:
+  provision:
+    endpoint: workflow
+    wait: true
+    artifacts: [ticket_number]
+`)
+	if err == nil || !strings.Contains(err.Error(), "artifacts are only available from Job Templates") {
+		t.Fatalf("expected artifacts to be rejected for the workflow endpoint, got %v", err)
+	}
+
+	if _, err := loadTestActions(t, `
+actions:
+  provision:
+    endpoint: job
+    wait: true
+    artifacts: [ticket_number]
+`); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	DefaultAdHocModule = "ping"
+)
+
+// AdHocConfig sets the ad hoc command run by the adhoc endpoint. Args and
+// Limit are templates, and Credential is an ID or the name of a credential
+type AdHocConfig struct {
+	Module               string         `yaml:"module,omitempty"`
+	Args                 string         `yaml:"args,omitempty"`
+	Limit                string         `yaml:"limit,omitempty"`
+	JobType              string         `yaml:"job_type,omitempty"`
+	Credential           string         `yaml:"credential,omitempty"`
+	ExecutionEnvironment int            `yaml:"execution_environment,omitempty"`
+	Verbosity            int            `yaml:"verbosity,omitempty"`
+	Forks                int            `yaml:"forks,omitempty"`
+	Become               bool           `yaml:"become,omitempty"`
+	ExtraVars            map[string]any `yaml:"extra_vars,omitempty"`
+}
+
+type AnsibleAdHocCommandRequest struct {
+	ModuleName           string `json:"module_name"`
+	ModuleArgs           string `json:"module_args,omitempty"`
+	Limit                string `json:"limit,omitempty"`
+	JobType              string `json:"job_type,omitempty"`
+	Credential           int    `json:"credential,omitempty"`
+	ExecutionEnvironment int    `json:"execution_environment,omitempty"`
+	Verbosity            int    `json:"verbosity,omitempty"`
+	Forks                int    `json:"forks,omitempty"`
+	BecomeEnabled        bool   `json:"become_enabled,omitempty"`
+	ExtraVars            string `json:"extra_vars,omitempty"`
+}
+
+type AnsibleAdHocCommandResponse struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	ModuleName string `json:"module_name"`
+	Limit      string `json:"limit"`
+	Status     string `json:"status"`
+}
+
+func buildAdHocCommandRequest(data *TemplateData, action ActionConfig, organisation int, ansibleAuth *AnsibleAuthResponse) (*AnsibleAdHocCommandRequest, error) {
+	adhoc := action.AdHoc
+
+	var adhocReq AnsibleAdHocCommandRequest
+	adhocReq.ModuleName = adhoc.Module
+	if len(adhocReq.ModuleName) == 0 {
+		adhocReq.ModuleName = DefaultAdHocModule
+	}
+	adhocReq.JobType = adhoc.JobType
+	adhocReq.ExecutionEnvironment = adhoc.ExecutionEnvironment
+	adhocReq.Verbosity = adhoc.Verbosity
+	adhocReq.Forks = adhoc.Forks
+	adhocReq.BecomeEnabled = adhoc.Become
+	adhocReq.ModuleArgs = adhoc.Args
+	adhocReq.Limit = adhoc.Limit
+
+	adhocStrings := map[string]*string{
+		"adhoc args":  &adhocReq.ModuleArgs,
+		"adhoc limit": &adhocReq.Limit,
+	}
+	if err := resolveLaunchStrings(data, adhocStrings, nil); err != nil {
+		return nil, err
+	}
+
+	extraVars, varsErr := renderExtraVars(adhoc.ExtraVars, data)
+	if varsErr != nil {
+		return nil, varsErr
+	}
+	encodedVars, encodeErr := encodeVariables(extraVars)
+	if encodeErr != nil {
+		return nil, encodeErr
+	}
+	adhocReq.ExtraVars = encodedVars
+
+	if len(adhoc.Credential) > 0 {
+		credential, credentialErr := ansibleFindCredential(adhoc.Credential, organisation, ansibleAuth)
+		if credentialErr != nil {
+			return nil, credentialErr
+		}
+		adhocReq.Credential = credential
+	}
+
+	return &adhocReq, nil
+}
+
+// checkAdHocInventory checks that the inventory an ad hoc command is run
+// against, which comes from the request path, is an ID or "workspace". It is
+// never rendered as a template
+func checkAdHocInventory(inventory string) error {
+	if inventory == WorkspaceInventory {
+		return nil
+	}
+	if id, parseErr := strconv.ParseUint(inventory, 10, 31); parseErr != nil || id == 0 {
+		return fmt.Errorf("the inventory to run an ad hoc command against must be an ID or %q, not %q", WorkspaceInventory, inventory)
+	}
+	return nil
+}
+
+// ansibleAdHocCommandRequest runs the action's ad hoc command against an
+// inventory already checked by checkAdHocInventory
+func ansibleAdHocCommandRequest(request RunTaskRequest, inventory string, action ActionConfig, ansibleAuth *AnsibleAuthResponse) (*AnsibleAdHocCommandResponse, error) {
+	data := newTemplateData(request)
+
+	inventoryID, invErr := lookupInventory(inventory, data, action, ansibleAuth)
+	if invErr != nil {
+		return nil, invErr
+	}
+	if inventoryID == 0 {
+		return nil, fmt.Errorf("an inventory is needed to run an ad hoc command")
+	}
+
+	adhocReq, buildErr := buildAdHocCommandRequest(data, action, action.Organization, ansibleAuth)
+	if buildErr != nil {
+		return nil, buildErr
+	}
+
+	var adhocResponse AnsibleAdHocCommandResponse
+	if err := ansibleAPIRequest(ansibleAuth, http.MethodPost, fmt.Sprintf("/api/v2/inventories/%d/ad_hoc_commands/", inventoryID), adhocReq, &adhocResponse); err != nil {
+		return nil, fmt.Errorf("unable to run ad hoc command %s: %w", adhocReq.ModuleName, err)
+	}
+
+	return &adhocResponse, nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestCheckAdHocInventory(t *testing.T) {
+	tests := []struct {
+		inventory string
+		valid     bool
+	}{
+		{"5", true},
+		{"workspace", true},
+		{"0", false},
+		{"-5", false},
+		{"+5", false},
+		{"5abc", false},
+		{"Workspace", false},
+		{"{{ .WorkspaceName }}", false},
+	}
+
+	for _, test := range tests {
+		err := checkAdHocInventory(test.inventory)
+		if (err == nil) != test.valid {
+			t.Errorf("checkAdHocInventory(%q) returned %v", test.inventory, err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type AnsibleListResponse[T any] struct {
+	Count    int    `json:"count"`
+	Next     string `json:"next,omitempty"`
+	Previous string `json:"previous,omitempty"`
+	Results  []T    `json:"results"`
+}
+
+// ansibleAPIRequest sends a request to the controller API, decoding a
+// successful JSON response into out if it is not nil
+func ansibleAPIRequest(ansibleAuth *AnsibleAuthResponse, method string, path string, payload any, out any) error {
+	var body io.Reader
+	if payload != nil {
+		jsonPayload, jsonErr := json.Marshal(payload)
+		if jsonErr != nil {
+			return jsonErr
+		}
+		body = bytes.NewBuffer(jsonPayload)
+	}
+
+	req, reqErr := http.NewRequest(method, ansibleHost+path, body)
+	if reqErr != nil {
+		return reqErr
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ansibleAuth.Token))
+
+	return ansibleDo(req, out)
+}
+
+// ansibleDo sends a prepared request to the controller, returning an
+// AnsibleAPIError unless it succeeds
+func ansibleDo(req *http.Request, out any) error {
+	response, respErr := ansibleClient.Do(req)
+	if respErr != nil {
+		return unavailableError(respErr)
+	}
+	defer response.Body.Close()
+
+	respBody, bodyErr := io.ReadAll(response.Body)
+	if bodyErr != nil {
+		return bodyErr
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return newAnsibleAPIError(req.Method, req.URL.Path, response, respBody)
+	}
+
+	if out != nil && len(respBody) > 0 {
+		if jsonErr := json.Unmarshal(respBody, out); jsonErr != nil {
+			return fmt.Errorf("unable to decode the response to %s %s: %s", req.Method, req.URL.Path, jsonErr)
+		}
+	}
+
+	return nil
+}
+
+// ansibleFindInventory looks up an inventory by name, within an organisation
+// if one is given. It returns nil if no inventory matches
+func ansibleFindInventory(name string, organisation int, ansibleAuth *AnsibleAuthResponse) (*AnsibleInventoryResponse, error) {
+	query := url.Values{}
+	query.Set("name", name)
+	if organisation != 0 {
+		query.Set("organization", fmt.Sprint(organisation))
+	}
+
+	var inventories AnsibleListResponse[AnsibleInventoryResponse]
+	if err := ansibleAPIRequest(ansibleAuth, http.MethodGet, "/api/v2/inventories/?"+query.Encode(), nil, &inventories); err != nil {
+		return nil, err
+	}
+
+	switch len(inventories.Results) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &inventories.Results[0], nil
+	default:
+		return nil, fmt.Errorf("found %d Ansible Inventories named %s, set the action organization to choose between them", len(inventories.Results), name)
+	}
+}
+
+// ansibleListRequest GETs every page of a controller API list
+func ansibleListRequest[T any](ansibleAuth *AnsibleAuthResponse, path string) ([]T, error) {
+	var results []T
+	for len(path) > 0 {
+		var page AnsibleListResponse[T]
+		if err := ansibleAPIRequest(ansibleAuth, http.MethodGet, path, nil, &page); err != nil {
+			return nil, err
+		}
+		results = append(results, page.Results...)
+		// next is normally relative, but may be absolute behind some proxies
+		path = strings.TrimPrefix(page.Next, ansibleHost)
+	}
+	return results, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// AnsibleAPIError is a controller API response that was not successful. AAP
+// describes what went wrong in the body, as a detail message, as __all__
+// messages about the request as a whole, or as messages for each field
+type AnsibleAPIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+	Messages   []string
+	Fields     map[string][]string
+}
+
+func newAnsibleAPIError(method string, path string, response *http.Response, body []byte) *AnsibleAPIError {
+	apiErr := &AnsibleAPIError{
+		Method:     method,
+		Path:       path,
+		StatusCode: response.StatusCode,
+		Status:     response.Status,
+		Fields:     map[string][]string{},
+	}
+
+	var decoded map[string]any
+	if json.Unmarshal(body, &decoded) != nil {
+		return apiErr
+	}
+	for field, value := range decoded {
+		switch field {
+		case "detail", "__all__":
+			apiErr.Messages = append(apiErr.Messages, errorMessages(value)...)
+		default:
+			apiErr.addField(field, value)
+		}
+	}
+	return apiErr
+}
+
+// addField adds the messages for a field, naming nested fields with dots
+// e.g. credentials.0.inputs
+func (e *AnsibleAPIError) addField(field string, value any) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, nested := range v {
+			e.addField(fmt.Sprintf("%s.%s", field, key), nested)
+		}
+	case []any:
+		var messages []string
+		for i, item := range v {
+			switch item.(type) {
+			case map[string]any, []any:
+				e.addField(fmt.Sprintf("%s.%d", field, i), item)
+			default:
+				messages = append(messages, errorMessages(item)...)
+			}
+		}
+		if len(messages) > 0 {
+			e.Fields[field] = append(e.Fields[field], messages...)
+		}
+	default:
+		e.Fields[field] = append(e.Fields[field], errorMessages(v)...)
+	}
+}
+
+// errorMessages returns a message, or list of messages, as strings
+func errorMessages(value any) []string {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return []string{v}
+	case []any:
+		var messages []string
+		for _, item := range v {
+			messages = append(messages, errorMessages(item)...)
+		}
+		return messages
+	default:
+		return []string{artifactValue(v)}
+	}
+}
+
+func (e *AnsibleAPIError) Error() string {
+	var reasons []string
+	for _, message := range e.Messages {
+		reasons = append(reasons, strings.TrimSuffix(message, "."))
+	}
+
+	var fields []string
+	for field := range e.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", field, strings.TrimSuffix(strings.Join(e.Fields[field], " "), ".")))
+	}
+
+	message := fmt.Sprintf("%s %s returned %s", e.Method, e.Path, e.Status)
+	if len(reasons) > 0 {
+		message = fmt.Sprintf("%s: %s", message, strings.Join(reasons, "; "))
+	}
+	if e.Retryable() {
+		message = fmt.Sprintf("%s. This is likely temporary, so the run can be retried", message)
+	}
+	return message
+}
+
+// Retryable reports whether the same request may succeed later, because the
+// controller was busy or unavailable rather than rejecting the request
+func (e *AnsibleAPIError) Retryable() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// isRetryableError reports whether a failed controller call may succeed if
+// made again. Only timeouts and connections that were refused or reset are
+// retried; certificate problems and malformed URLs will fail every time
+func isRetryableError(err error) bool {
+	var apiErr *AnsibleAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+	var unavailable *ControllerUnavailableError
+	if errors.As(err, &unavailable) {
+		return true
+	}
+	if isCertificateError(err) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isCertificateError reports whether err is the controller's TLS certificate
+// failing verification
+func isCertificateError(err error) bool {
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var headerErr tls.RecordHeaderError
+	return errors.As(err, &verifyErr) || errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) || errors.As(err, &headerErr)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"syscall"
+	"testing"
+)
+
+// rejectingController fails the request matching method and path with a
+// controller error, answering every other request with an empty object
+func rejectingController(t *testing.T, method string, path string) *AnsibleAuthResponse {
+	return newTestController(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == method && r.URL.Path == path {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"detail": "Request refused."}`))
+			return
+		}
+		if r.Method == http.MethodGet && r.URL.Path == "/api/v2/inventories/" {
+			w.Write([]byte(`{"count": 1, "results": [{"id": 7, "name": "web-prod"}]}`))
+			return
+		}
+		w.Write([]byte(`{"id": 8, "name": "web-prod"}`))
+	})
+}
+
+func TestControllerErrorsAreWrapped(t *testing.T) {
+	request := RunTaskRequest{RunID: "run-1", WorkspaceName: "web-prod"}
+
+	var approvalNode AnsibleWorkflowNode
+	approvalNode.Job = 3
+	approvalNode.SummaryFields.Job.Type = WorkflowApproval
+	approvalNode.SummaryFields.Job.Status = "pending"
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		call   func(*AnsibleAuthResponse) error
+	}{
+		{"preflight", http.MethodGet, "/api/v2/job_templates/5/launch/", func(auth *AnsibleAuthResponse) error {
+			_, err := ansibleLaunchRequirementsRequest("/api/v2/job_templates/5/launch/", auth)
+			return err
+		}},
+		{"survey", http.MethodGet, "/api/v2/job_templates/5/survey_spec/", func(auth *AnsibleAuthResponse) error {
+			_, err := ansibleSurveySpecRequest("/api/v2/job_templates/5/survey_spec/", auth)
+			return err
+		}},
+		{"gate", http.MethodGet, "/api/v2/jobs/9/job_host_summaries/", func(auth *AnsibleAuthResponse) error {
+			_, err := ansibleGateResultRequest(&AnsibleUnifiedJob{ID: 9, Name: "deploy"}, auth)
+			return err
+		}},
+		{"workflow", http.MethodGet, "/api/v2/workflow_approvals/3/", func(auth *AnsibleAuthResponse) error {
+			_, err := ansibleWorkflowApprovalsRequest([]AnsibleWorkflowNode{approvalNode}, auth)
+			return err
+		}},
+		{"inventory", http.MethodPost, "/api/v2/constructed_inventories/8/input_inventories/", func(auth *AnsibleAuthResponse) error {
+			action := ActionConfig{Inventory: InventoryConfig{Kind: ConstructedInventory, InputInventories: []string{"3"}}}
+			_, err := ansibleCreateConstructedInventoryRequest(newTemplateData(request), "web-prod", "", "", 1, action, auth)
+			return err
+		}},
+		{"destroy", http.MethodDelete, "/api/v2/inventories/7/", func(auth *AnsibleAuthResponse) error {
+			action := ActionConfig{Destroy: DestroyConfig{Inventory: DestroyDeleteInventory}}
+			_, err := ansibleTeardownInventoryRequest(request, 1, action, auth)
+			return err
+		}},
+		{"project", http.MethodGet, "/api/v2/job_templates/5/", func(auth *AnsibleAuthResponse) error {
+			_, _, err := ansibleSyncProjectRequest(request, "5", ActionConfig{ProjectSync: ProjectSyncConfig{Enabled: true}}, auth)
+			return err
+		}},
+		{"sources", http.MethodPost, "/api/v2/inventories/8/update_inventory_sources/", func(auth *AnsibleAuthResponse) error {
+			return ansibleSyncInventorySourcesRequest(request, &AnsibleInventoryResponse{ID: 8, Name: "web-prod"}, 1, ActionConfig{}, auth)
+		}},
+		{"reconcile", http.MethodGet, "/api/v2/inventories/8/groups/", func(auth *AnsibleAuthResponse) error {
+			return reconcileGroups(8, map[string]*desiredGroup{}, map[string]int{}, &reconcileSummary{}, auth)
+		}},
+		{"adhoc", http.MethodPost, "/api/v2/inventories/3/ad_hoc_commands/", func(auth *AnsibleAuthResponse) error {
+			_, err := ansibleAdHocCommandRequest(request, "3", ActionConfig{}, auth)
+			return err
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			auth := rejectingController(t, test.method, test.path)
+
+			err := test.call(auth)
+			var apiErr *AnsibleAPIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("expected an AnsibleAPIError, got %v", err)
+			}
+			if apiErr.StatusCode != http.StatusBadRequest || apiErr.Path != test.path {
+				t.Errorf("expected the error for %s %s, got %s %s returning %d", test.method, test.path, apiErr.Method, apiErr.Path, apiErr.StatusCode)
+			}
+		})
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsRetryableError(t *testing.T) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedURL := closed.URL
+	closed.Close()
+	_, refusedErr := http.Get(closedURL)
+
+	untrusted := httptest.NewTLSServer(http.NotFoundHandler())
+	defer untrusted.Close()
+	_, certificateErr := http.Get(untrusted.URL)
+
+	_, invalidErr := http.Get("https://controller example/api/v2/")
+	_, schemeErr := http.Get("controller.example/api/v2/")
+
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"timeout", &url.Error{Op: "Get", URL: "https://controller.example/", Err: timeoutError{}}, true},
+		{"connection refused", refusedErr, true},
+		{"connection reset", fmt.Errorf("unable to check job: %w", &url.Error{Op: "Get", URL: "https://controller.example/", Err: syscall.ECONNRESET}), true},
+		{"server error", &AnsibleAPIError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"client error", &AnsibleAPIError{StatusCode: http.StatusBadRequest}, false},
+		{"untrusted certificate", certificateErr, false},
+		{"invalid url", invalidErr, false},
+		{"unsupported scheme", schemeErr, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.err == nil {
+				t.Fatal("expected the request to fail")
+			}
+			if got := isRetryableError(test.err); got != test.retryable {
+				t.Errorf("expected isRetryableError(%v) to be %t", test.err, test.retryable)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerHalfOpen = "half-open"
+	BreakerOpen     = "open"
+
+	DefaultBreakerFailures = 5
+	DefaultBreakerCooldown = time.Second * 30
+)
+
+var breakerFailures = DefaultBreakerFailures
+var breakerCooldown = DefaultBreakerCooldown
+var breakerPassAdvisory bool
+
+// ControllerUnavailableError is returned, without contacting the controller,
+// while its circuit breaker is open
+type ControllerUnavailableError struct {
+	Host    string
+	RetryAt time.Time
+}
+
+func (e *ControllerUnavailableError) Error() string {
+	wait := time.Until(e.RetryAt).Round(time.Second)
+	if wait <= 0 {
+		return fmt.Sprintf("controller unavailable: %s has failed repeatedly, and ARTS is checking whether it has recovered", e.Host)
+	}
+	return fmt.Sprintf("controller unavailable: %s has failed repeatedly, and ARTS will try it again in %s", e.Host, wait)
+}
+
+// circuitBreaker stops requests to a controller after breakerFailures
+// requests in a row fail. Once breakerCooldown has passed, a single probe
+// request is let through, closing the breaker if it succeeds
+type circuitBreaker struct {
+	host string
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	retryAt  time.Time
+	probing  bool
+	opens    int
+	rejected int
+}
+
+func newCircuitBreaker(host string) *circuitBreaker {
+	return &circuitBreaker{host: host, state: BreakerClosed}
+}
+
+// allow returns a ControllerUnavailableError if a request must not be sent
+func (b *circuitBreaker) allow() error {
+	if breakerFailures == 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Now().Before(b.retryAt) {
+			b.rejected++
+			return &ControllerUnavailableError{Host: b.host, RetryAt: b.retryAt}
+		}
+		log.Printf("Circuit breaker for %s is half-open, probing the controller", b.host)
+		b.state = BreakerHalfOpen
+		b.probing = true
+	case BreakerHalfOpen:
+		if b.probing {
+			b.rejected++
+			return &ControllerUnavailableError{Host: b.host, RetryAt: b.retryAt}
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// record counts a request as failed if the controller could not be reached
+// or returned a server error. Requests cancelled by ARTS are not counted
+func (b *circuitBreaker) record(req *http.Request, response *http.Response, respErr error) {
+	if breakerFailures == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasProbe := b.probing
+	b.probing = false
+	if req.Context().Err() != nil {
+		return
+	}
+
+	if respErr == nil && response.StatusCode < http.StatusInternalServerError {
+		if b.state != BreakerClosed {
+			log.Printf("Circuit breaker for %s is closed, the controller has recovered", b.host)
+		}
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if (b.state == BreakerHalfOpen && wasProbe) || (b.state == BreakerClosed && b.failures >= breakerFailures) {
+		b.state = BreakerOpen
+		b.retryAt = time.Now().Add(breakerCooldown)
+		b.opens++
+		log.Printf("Circuit breaker for %s is open after %d failed requests, retrying in %s", b.host, b.failures, breakerCooldown)
+	}
+}
+
+// breakerStatus is a snapshot of a circuit breaker for readiness and metrics
+type breakerStatus struct {
+	Host                string `json:"-"`
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	Opens               int    `json:"opens"`
+	Rejected            int    `json:"rejected"`
+}
+
+func (b *circuitBreaker) status() breakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.state
+	// an open breaker whose cooldown has passed lets the next request probe
+	if state == BreakerOpen && !time.Now().Before(b.retryAt) {
+		state = BreakerHalfOpen
+	}
+	return breakerStatus{Host: b.host, State: state, ConsecutiveFailures: b.failures, Opens: b.opens, Rejected: b.rejected}
+}
+
+func (t *controllerTransport) breakerStatuses() []breakerStatus {
+	t.mu.Lock()
+	var statuses []breakerStatus
+	for _, c := range t.controllers {
+		statuses = append(statuses, c.breaker.status())
+	}
+	t.mu.Unlock()
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Host < statuses[j].Host })
+	return statuses
+}
+
+// controllerHost returns the host requests to a controller URL are sent to
+func controllerHost(controllerURL string) string {
+	parsed, parseErr := url.Parse(controllerURL)
+	if parseErr != nil {
+		return ""
+	}
+	return parsed.Host
+}
+
+// unavailableError returns the ControllerUnavailableError within an error
+// from the HTTP client, so that messages do not repeat the request URL
+func unavailableError(err error) error {
+	var unavailable *ControllerUnavailableError
+	if errors.As(err, &unavailable) {
+		return unavailable
+	}
+	return err
+}
+
+// tokenFailedResponse is the Run Task response when no controller token could
+// be had. With ARTS_ANSIBLE_BREAKER_PASS_ADVISORY, advisory Run Tasks pass
+// while the controller's circuit breaker is open
+func tokenFailedResponse(request RunTaskRequest, tokErr error) *RunTaskResponse {
+	var unavailable *ControllerUnavailableError
+	if breakerPassAdvisory && errors.As(tokErr, &unavailable) && request.TaskResultEnforcementLevel != MandatoryEnforcement {
+		return createRunTaskResponse(Passed, fmt.Sprintf("Skipped, %s. This Run Task is advisory, so it has passed", tokErr), "")
+	}
+	return createRunTaskResponse(Failed, tokErr.Error(), "")
+}
+
+// handleReadiness reports the state of each controller's circuit breaker. It
+// always responds 200 OK, as ARTS still has to answer TFC/TFE while a
+// controller is unavailable, so it can be used as a readiness probe
+func handleReadiness(c *gin.Context) {
+	ready := "ready"
+	controllers := map[string]breakerStatus{}
+	for _, breaker := range ansibleTransport.breakerStatuses() {
+		controllers[breaker.Host] = breaker
+		if breaker.State == BreakerOpen {
+			ready = "controller unavailable"
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"status": ready, "controllers": controllers})
+}
+
+// handleMetrics serves circuit breaker and queue metrics in the Prometheus
+// text format
+func handleMetrics(c *gin.Context) {
+	statuses := ansibleTransport.breakerStatuses()
+	var metrics strings.Builder
+
+	metric := func(name string, kind string, help string, value func(breakerStatus) int) {
+		fmt.Fprintf(&metrics, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, status := range statuses {
+			fmt.Fprintf(&metrics, "%s{controller=%q} %d\n", name, status.Host, value(status))
+		}
+	}
+	metric("arts_controller_breaker_state", "gauge", "Circuit breaker state of each controller: 0 closed, 1 half-open, 2 open", func(s breakerStatus) int {
+		switch s.State {
+		case BreakerOpen:
+			return 2
+		case BreakerHalfOpen:
+			return 1
+		default:
+			return 0
+		}
+	})
+	metric("arts_controller_breaker_consecutive_failures", "gauge", "Requests to each controller that have failed in a row", func(s breakerStatus) int { return s.ConsecutiveFailures })
+	metric("arts_controller_breaker_opens_total", "counter", "Times each controller's circuit breaker has opened", func(s breakerStatus) int { return s.Opens })
+	metric("arts_controller_breaker_rejected_total", "counter", "Requests not sent to each controller because its circuit breaker was open", func(s breakerStatus) int { return s.Rejected })
+
+	fmt.Fprintf(&metrics, "# HELP arts_run_task_queue_length Run Tasks being processed\n# TYPE arts_run_task_queue_length gauge\narts_run_task_queue_length %d\n", len(runTaskQueue))
+	fmt.Fprintf(&metrics, "# HELP arts_run_task_queue_size Run Tasks that can be processed at once, or 0 for no limit\n# TYPE arts_run_task_queue_size gauge\narts_run_task_queue_size %d\n", cap(runTaskQueue))
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(metrics.String()))
+}
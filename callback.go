@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	DefaultTFCAllowedHosts = "app.terraform.io"
+)
+
+var tfcAllowedHosts []string
+var tfcAllowPrivateNetworks bool
+
+// carrier-grade NAT space is not covered by net.IP.IsPrivate
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func parseAllowedHosts(hosts string) []string {
+	var allowed []string
+	for _, host := range strings.Split(hosts, ",") {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if len(host) > 0 {
+			allowed = append(allowed, host)
+		}
+	}
+	return allowed
+}
+
+func isDisallowedIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip)
+}
+
+// validateTFCURL checks that a URL supplied in a Run Task payload points at an
+// allowed TFC/TFE host over HTTPS before ARTS sends it a bearer token
+func validateTFCURL(uri string) error {
+	parsed, parseErr := url.Parse(uri)
+	if parseErr != nil {
+		return fmt.Errorf("unable to parse URL: %s", parseErr)
+	}
+
+	if parsed.Scheme != "https" {
+		return fmt.Errorf("URL scheme %q is not https", parsed.Scheme)
+	}
+
+	if len(parsed.User.String()) > 0 {
+		return fmt.Errorf("URL must not contain credentials")
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	allowed := false
+	for _, allowedHost := range tfcAllowedHosts {
+		if host == allowedHost {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("host %q is not in ARTS_TFC_ALLOWED_HOSTS", host)
+	}
+
+	if tfcAllowPrivateNetworks {
+		return nil
+	}
+
+	// when the request goes through a proxy, the proxy resolves the host
+	if transport, ok := tfcClient.Transport.(*http.Transport); ok && transport.Proxy != nil {
+		proxyURL, proxyErr := transport.Proxy(&http.Request{URL: parsed})
+		if proxyErr == nil && proxyURL != nil {
+			return nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	addrs, lookupErr := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if lookupErr != nil {
+		return fmt.Errorf("unable to resolve host %q: %s", host, lookupErr)
+	}
+
+	for _, addr := range addrs {
+		if isDisallowedIP(addr.IP) {
+			return fmt.Errorf("host %q resolves to non-public address %s", host, addr.IP)
+		}
+	}
+
+	return nil
+}
+
+// tfcDialContext only connects to public addresses. validateTFCURL resolves
+// the host when a Run Task arrives, but the transport resolves it again when
+// connecting, by when it may point elsewhere, so the address dialled is
+// checked too. Connections to the proxy are not checked, as it resolves the
+// host itself
+func tfcDialContext(dialer *net.Dialer, proxyAddr string) func(ctx context.Context, network string, addr string) (net.Conn, error) {
+	guarded := *dialer
+	guarded.Control = func(network string, address string, _ syscall.RawConn) error {
+		if tfcAllowPrivateNetworks {
+			return nil
+		}
+		host, _, splitErr := net.SplitHostPort(address)
+		if splitErr != nil {
+			return splitErr
+		}
+		if ip := net.ParseIP(host); ip == nil || isDisallowedIP(ip) {
+			return fmt.Errorf("refused to connect to non-public address %s", host)
+		}
+		return nil
+	}
+
+	return func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		if len(proxyAddr) > 0 && addr == proxyAddr {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		return guarded.DialContext(ctx, network, addr)
+	}
+}
+
+// validateRunTaskCallback rejects a Run Task request whose callback URL fails
+// validation, logging it as a security event. It returns false if the request
+// was rejected
+func validateRunTaskCallback(c *gin.Context, runTask RunTaskRequest) bool {
+	if runTask.AccessToken == TestToken {
+		return true
+	}
+
+	if err := validateTFCURL(runTask.TaskResultCallbackURL); err != nil {
+		log.Printf("SECURITY: rejected Run Task from %s for run %s with callback URL %q: %s", c.ClientIP(), runTask.RunID, runTask.TaskResultCallbackURL, err)
+		c.Status(http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	WorkspaceConcurrency  = "workspace"
+	TemplateConcurrency   = "template"
+	ControllerConcurrency = "controller"
+)
+
+// ConcurrencyConfig limits how many Run Tasks sharing a key run at once.
+// Run Tasks over a limit are queued until a slot is free, or until MaxWait
+// passes, which defaults to ARTS_WAIT_TIMEOUT
+type ConcurrencyConfig struct {
+	Limits  []ConcurrencyLimit `yaml:"limits,omitempty"`
+	MaxWait time.Duration      `yaml:"max_wait,omitempty"`
+}
+
+// ConcurrencyLimit allows Limit Run Tasks to run at once for each workspace,
+// each template, or the controller, defaulting to one
+type ConcurrencyLimit struct {
+	Key   string `yaml:"key"`
+	Limit int    `yaml:"limit,omitempty"`
+}
+
+// concurrencySlot is one key a Run Task needs a slot for
+type concurrencySlot struct {
+	key         string
+	limit       int
+	description string
+}
+
+// concurrencySlots counts the running Run Tasks for each key. released is
+// closed, and replaced, whenever slots are freed to wake queued Run Tasks
+type concurrencySlots struct {
+	mu       sync.Mutex
+	running  map[string]int
+	released chan struct{}
+}
+
+var slots = &concurrencySlots{
+	running:  map[string]int{},
+	released: make(chan struct{}),
+}
+
+func checkConcurrencyConfig(concurrency ConcurrencyConfig) error {
+	for _, limit := range concurrency.Limits {
+		switch limit.Key {
+		case WorkspaceConcurrency, TemplateConcurrency, ControllerConcurrency:
+		default:
+			return fmt.Errorf("concurrency key must be %s, %s or %s, not %q", WorkspaceConcurrency, TemplateConcurrency, ControllerConcurrency, limit.Key)
+		}
+		if limit.Limit < 0 {
+			return fmt.Errorf("concurrency limit for %s must not be negative", limit.Key)
+		}
+	}
+	if concurrency.MaxWait < 0 {
+		return fmt.Errorf("concurrency max_wait must not be negative")
+	}
+	return nil
+}
+
+// concurrencySlotsFor returns the slots a Run Task needs. template names the
+// endpoint and the ID in its URL, such as the Job Template ID
+func concurrencySlotsFor(request RunTaskRequest, action ActionConfig, endpoint string, template string) []concurrencySlot {
+	var needed []concurrencySlot
+	for _, limit := range action.Concurrency.Limits {
+		slot := concurrencySlot{limit: limit.Limit}
+		if slot.limit == 0 {
+			slot.limit = 1
+		}
+		switch limit.Key {
+		case WorkspaceConcurrency:
+			slot.key = fmt.Sprintf("workspace/%s", request.WorkspaceID)
+			slot.description = fmt.Sprintf("workspace %s", request.WorkspaceName)
+		case TemplateConcurrency:
+			slot.key = fmt.Sprintf("%s/%s", endpoint, template)
+			slot.description = fmt.Sprintf("%s %s", endpoint, template)
+		case ControllerConcurrency:
+			slot.key = fmt.Sprintf("controller/%s", ansibleHost)
+			slot.description = "the controller"
+		}
+		needed = append(needed, slot)
+	}
+
+	// slots are always taken in the same order
+	sort.Slice(needed, func(i, j int) bool { return needed[i].key < needed[j].key })
+	return needed
+}
+
+// tryAcquire takes every slot if all are free, returning the first that is
+// full if not
+func (s *concurrencySlots) tryAcquire(needed []concurrencySlot) (*concurrencySlot, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range needed {
+		if s.running[needed[i].key] >= needed[i].limit {
+			return &needed[i], s.released
+		}
+	}
+	for _, slot := range needed {
+		s.running[slot.key]++
+	}
+	return nil, nil
+}
+
+func (s *concurrencySlots) release(needed []concurrencySlot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, slot := range needed {
+		s.running[slot.key]--
+		if s.running[slot.key] <= 0 {
+			delete(s.running, slot.key)
+		}
+	}
+	close(s.released)
+	s.released = make(chan struct{})
+}
+
+// acquireConcurrency waits for a slot for each of the action's concurrency
+// keys, sending a running update while queued. The returned function frees
+// the slots once the Run Task is done
+func acquireConcurrency(request RunTaskRequest, action ActionConfig, endpoint string, template string) (func(), error) {
+	needed := concurrencySlotsFor(request, action, endpoint, template)
+	if len(needed) == 0 {
+		return func() {}, nil
+	}
+
+	maxWait := action.Concurrency.MaxWait
+	if maxWait == 0 {
+		maxWait = waitTimeout
+	}
+	deadline := time.NewTimer(maxWait)
+	defer deadline.Stop()
+
+	var queuedBehind string
+	for {
+		full, released := slots.tryAcquire(needed)
+		if full == nil {
+			return func() { slots.release(needed) }, nil
+		}
+
+		if full.description != queuedBehind {
+			queuedBehind = full.description
+			log.Printf("Run Task for %s %s queued behind other runs of %s", endpoint, template, full.description)
+			running := createRunTaskResponse(Running, fmt.Sprintf("Queued behind another run of %s, waiting for it to finish", full.description), "")
+			tfcRunTaskResponse(running, request.TaskResultCallbackURL, request.AccessToken)
+		}
+
+		select {
+		case <-released:
+		case <-deadline.C:
+			return nil, fmt.Errorf("timed out after %s queued behind other runs of %s", maxWait, full.description)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	DestroyDeleteInventory  = "delete"
+	DestroyArchiveInventory = "archive"
+)
+
+// DestroyConfig sets what the inventory endpoint does for destroy runs: an
+// optional Job Template launched against the workspace inventory before
+// apply, and whether the inventory is deleted or archived after apply
+type DestroyConfig struct {
+	DecommissionTemplate int          `yaml:"decommission_template,omitempty"`
+	Launch               LaunchConfig `yaml:"launch,omitempty"`
+	Inventory            string       `yaml:"inventory,omitempty"`
+}
+
+type AnsibleInventoryUpdateRequest struct {
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+func (d DestroyConfig) enabled() bool {
+	return d.DecommissionTemplate != 0 || len(d.Inventory) > 0
+}
+
+// isDestroyPlan reports whether every change in a plan deletes a resource.
+// Data source reads and no-ops are ignored
+func isDestroyPlan(plan *TFCPlan) bool {
+	deletes := 0
+	for _, change := range plan.ResourceChanges {
+		for _, action := range change.Change.Actions {
+			switch action {
+			case "no-op", "read":
+			case "delete":
+				deletes++
+			default:
+				return false
+			}
+		}
+	}
+	return deletes > 0
+}
+
+// tfcIsDestroyRun reports whether a run destroys the workspace, from its plan
+// where there is one, and otherwise from the run itself
+func tfcIsDestroyRun(request RunTaskRequest) (bool, error) {
+	if len(request.PlanJSONAPIURL) > 0 {
+		plan, planErr := tfcPlanJSON(request)
+		if planErr != nil {
+			return false, planErr
+		}
+		return isDestroyPlan(plan), nil
+	}
+
+	run, runErr := tfcRun(request)
+	if runErr != nil {
+		return false, runErr
+	}
+
+	return run.Data.Attributes.IsDestroy, nil
+}
+
+// ansibleDecommissionRequest launches the destroy decommission Job Template,
+// against the workspace inventory unless the launch options say otherwise. It
+// returns a nil response if there is no inventory to decommission
+func ansibleDecommissionRequest(request RunTaskRequest, organisation int, action ActionConfig, ansibleAuth *AnsibleAuthResponse) (*AnsibleJobTemplateResponse, error) {
+	decommission := ActionConfig{
+		Organization: action.Organization,
+		Launch:       action.Destroy.Launch,
+		Inventory:    action.Inventory,
+	}
+	if len(decommission.Launch.Inventory) == 0 {
+		decommission.Launch.Inventory = WorkspaceInventory
+	}
+	if decommission.Organization == 0 {
+		decommission.Organization = organisation
+	}
+
+	if decommission.Launch.Inventory == WorkspaceInventory {
+		name, nameErr := inventoryName(newTemplateData(request), action)
+		if nameErr != nil {
+			return nil, nameErr
+		}
+
+		inventory, findErr := ansibleFindInventory(name, decommission.Organization, ansibleAuth)
+		if findErr != nil {
+			return nil, findErr
+		}
+		if inventory == nil {
+			return nil, nil
+		}
+	}
+
+	return ansibleJobTemplateRequest(request, fmt.Sprint(action.Destroy.DecommissionTemplate), decommission, ansibleAuth)
+}
+
+// ansibleTeardownInventoryRequest deletes or archives the workspace inventory
+// after a destroy run. Archived inventories are renamed so that a new
+// workspace of the same name starts with a fresh inventory. It returns a nil
+// inventory if there was none to tear down
+func ansibleTeardownInventoryRequest(request RunTaskRequest, organisation int, action ActionConfig, ansibleAuth *AnsibleAuthResponse) (*AnsibleInventoryResponse, error) {
+	name, nameErr := inventoryName(newTemplateData(request), action)
+	if nameErr != nil {
+		return nil, nameErr
+	}
+
+	inventory, findErr := ansibleFindInventory(name, organisation, ansibleAuth)
+	if findErr != nil || inventory == nil {
+		return nil, findErr
+	}
+
+	path := fmt.Sprintf("/api/v2/inventories/%d/", inventory.ID)
+
+	if action.Destroy.Inventory == DestroyDeleteInventory {
+		if err := ansibleAPIRequest(ansibleAuth, http.MethodDelete, path, nil, nil); err != nil {
+			return nil, fmt.Errorf("unable to delete Ansible Inventory %s: %w", inventory.Name, err)
+		}
+		return inventory, nil
+	}
+
+	var archiveReq AnsibleInventoryUpdateRequest
+	archiveReq.Name = fmt.Sprintf("%s-archived-%s", inventory.Name, request.RunID)
+	archiveReq.Description = fmt.Sprintf("Archived by destroy run %s on %s", request.RunID, time.Now().UTC().Format(time.RFC3339))
+
+	var archived AnsibleInventoryResponse
+	if err := ansibleAPIRequest(ansibleAuth, http.MethodPatch, path, archiveReq, &archived); err != nil {
+		return nil, fmt.Errorf("unable to archive Ansible Inventory %s: %w", inventory.Name, err)
+	}
+
+	return &archived, nil
+}
+
+// destroyRunTaskResponse handles the inventory endpoint for a destroy run,
+// decommissioning before apply and tearing the inventory down after it
+func destroyRunTaskResponse(request RunTaskRequest, organisation int, action ActionConfig, ansibleAuth *AnsibleAuthResponse) *RunTaskResponse {
+	switch {
+	case request.Stage == PreApply && action.Destroy.DecommissionTemplate != 0:
+		jobTemplateResponse, jtErr := ansibleDecommissionRequest(request, organisation, action, ansibleAuth)
+		if jtErr != nil {
+			return actionRunTaskResponse(action, request, Failed, jtErr.Error(), "")
+		}
+		if jobTemplateResponse == nil {
+			return actionRunTaskResponse(action, request, Passed, "Destroy run, no Ansible Inventory to decommission", "")
+		}
+		// the destroy must not be applied until the decommission has finished
+		message := fmt.Sprintf("Destroy run, succesfully triggered decommission Job Template, %s%s", jobTemplateResponse.Name, ignoredFieldsMessage(jobTemplateResponse.IgnoredFields))
+		detailsURL := fmt.Sprintf("%s/#/jobs/playbook/%d/output", ansibleHost, jobTemplateResponse.ID)
+		return waitedRunTaskResponse(action, request, message, fmt.Sprintf("/api/v2/jobs/%d/", jobTemplateResponse.ID), detailsURL, ansibleAuth)
+
+	case request.Stage == PostApply && len(action.Destroy.Inventory) > 0:
+		inventory, teardownErr := ansibleTeardownInventoryRequest(request, organisation, action, ansibleAuth)
+		if teardownErr != nil {
+			return actionRunTaskResponse(action, request, Failed, teardownErr.Error(), "")
+		}
+		if inventory == nil {
+			return actionRunTaskResponse(action, request, Passed, "Destroy run, no Ansible Inventory to remove", "")
+		}
+		if action.Destroy.Inventory == DestroyDeleteInventory {
+			return actionRunTaskResponse(action, request, Passed, fmt.Sprintf("Destroy run, successfully deleted Ansible Inventory %s", inventory.Name), "")
+		}
+		return actionRunTaskResponse(action, request, Passed, fmt.Sprintf("Destroy run, successfully archived Ansible Inventory as %s", inventory.Name), inventoryDetailsURL(inventory))
+	}
+
+	return actionRunTaskResponse(action, request, Passed, fmt.Sprintf("Destroy run, nothing to do at %s", request.Stage), "")
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	MaxFailureSummary    = 1000
+	MaxFailedEvents      = 5
+	MaxFailureLineLength = 200
+)
+
+// masks values of secret-looking keys e.g. password=hunter2 or "token": "abc"
+var secretValues = regexp.MustCompile(`(?i)((?:password|passwd|secret|token|api_?key|private_?key)["']?\s*[:=]\s*)("[^"]*"|'[^']*'|\S+)`)
+
+type AnsibleJobEvent struct {
+	ID        int    `json:"id"`
+	Event     string `json:"event"`
+	Task      string `json:"task"`
+	HostName  string `json:"host_name"`
+	Failed    bool   `json:"failed"`
+	EventData struct {
+		IgnoreErrors bool `json:"ignore_errors"`
+		Res          struct {
+			Msg    any    `json:"msg"`
+			Stderr string `json:"stderr"`
+			NoLog  bool   `json:"_ansible_no_log"`
+		} `json:"res"`
+	} `json:"event_data"`
+}
+
+type AnsibleJobHostSummary struct {
+	HostName string `json:"host_name"`
+	Changed  int    `json:"changed"`
+	Failures int    `json:"failures"`
+	Dark     int    `json:"dark"`
+	Failed   bool   `json:"failed"`
+}
+
+// failedEvent reports whether an event is a task failing on a host, rather
+// than a parent event marked failed because of one
+func (e AnsibleJobEvent) failedEvent() bool {
+	switch e.Event {
+	case "runner_on_failed", "runner_item_on_failed", "runner_on_unreachable":
+		return !e.EventData.IgnoreErrors
+	default:
+		return false
+	}
+}
+
+// errorLine returns the first line of an event's error, respecting no_log
+func (e AnsibleJobEvent) errorLine() string {
+	res := e.EventData.Res
+	if res.NoLog {
+		return "output hidden by no_log"
+	}
+
+	text := res.Stderr
+	if msg, ok := res.Msg.(string); ok && len(msg) > 0 {
+		text = msg
+	} else if res.Msg != nil && len(text) == 0 {
+		text = fmt.Sprint(res.Msg)
+	}
+
+	line, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	return truncate(redactSecrets(line), MaxFailureLineLength)
+}
+
+func redactSecrets(s string) string {
+	return secretValues.ReplaceAllString(s, "${1}********")
+}
+
+// failureEventsPath returns where a job's failed events are listed, which
+// differs for ad hoc commands
+func failureEventsPath(job *AnsibleUnifiedJob) string {
+	switch job.Type {
+	case "job":
+		return fmt.Sprintf("/api/v2/jobs/%d/job_events/?failed=true&order_by=counter&page_size=50", job.ID)
+	case "ad_hoc_command":
+		return fmt.Sprintf("/api/v2/ad_hoc_commands/%d/events/?failed=true&order_by=counter&page_size=50", job.ID)
+	default:
+		return ""
+	}
+}
+
+// ansibleFailureSummaryRequest describes why a job failed, from its failed
+// events and host summaries. It returns an empty summary if there is nothing
+// to add to the job's status
+func ansibleFailureSummaryRequest(job *AnsibleUnifiedJob, ansibleAuth *AnsibleAuthResponse) string {
+	path := failureEventsPath(job)
+	if len(path) == 0 {
+		return ""
+	}
+
+	var parts []string
+
+	if job.Type == "job" {
+		summaries, summariesErr := ansibleListRequest[AnsibleJobHostSummary](ansibleAuth, fmt.Sprintf("/api/v2/jobs/%d/job_host_summaries/?page_size=200", job.ID))
+		if summariesErr == nil {
+			var failed, unreachable []string
+			for _, summary := range summaries {
+				if summary.Dark > 0 {
+					unreachable = append(unreachable, summary.HostName)
+				} else if summary.Failures > 0 || summary.Failed {
+					failed = append(failed, summary.HostName)
+				}
+			}
+			sort.Strings(failed)
+			sort.Strings(unreachable)
+			if len(failed) > 0 {
+				parts = append(parts, fmt.Sprintf("%d hosts failed: %s", len(failed), strings.Join(failed, ", ")))
+			}
+			if len(unreachable) > 0 {
+				parts = append(parts, fmt.Sprintf("%d hosts unreachable: %s", len(unreachable), strings.Join(unreachable, ", ")))
+			}
+		}
+	}
+
+	// only the first page is needed, so this is not a list request
+	var events AnsibleListResponse[AnsibleJobEvent]
+	if err := ansibleAPIRequest(ansibleAuth, http.MethodGet, path, nil, &events); err == nil {
+		shown := 0
+		for _, event := range events.Results {
+			if !event.failedEvent() {
+				continue
+			}
+			if shown == MaxFailedEvents {
+				parts = append(parts, "...")
+				break
+			}
+			parts = append(parts, fmt.Sprintf("task %q on %s: %s", event.Task, event.HostName, event.errorLine()))
+			shown++
+		}
+	}
+
+	return truncate(strings.Join(parts, "; "), MaxFailureSummary)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	CheckJobType = "check"
+
+	MandatoryEnforcement = "mandatory"
+)
+
+// GateConfig launches the Job Template in check mode with diff_mode, waits
+// for it, and fails the Run Task when more hosts would change, or more hosts
+// failed, than allowed
+type GateConfig struct {
+	Enabled     bool `yaml:"enabled,omitempty"`
+	MaxChanged  int  `yaml:"max_changed,omitempty"`
+	MaxFailures int  `yaml:"max_failures,omitempty"`
+}
+
+// gateResult counts the hosts a check mode job would change and the hosts
+// that failed or were unreachable
+type gateResult struct {
+	changed []string
+	failed  []string
+}
+
+// gateAction forces check mode and diff_mode on an action's launch
+func gateAction(action ActionConfig) ActionConfig {
+	diffMode := true
+	action.Launch.JobType = CheckJobType
+	action.Launch.DiffMode = &diffMode
+	return action
+}
+
+func ansibleGateResultRequest(job *AnsibleUnifiedJob, ansibleAuth *AnsibleAuthResponse) (*gateResult, error) {
+	summaries, summariesErr := ansibleListRequest[AnsibleJobHostSummary](ansibleAuth, fmt.Sprintf("/api/v2/jobs/%d/job_host_summaries/?page_size=200", job.ID))
+	if summariesErr != nil {
+		return nil, fmt.Errorf("unable to read host summaries of %s: %w", job.Name, summariesErr)
+	}
+
+	var result gateResult
+	for _, summary := range summaries {
+		if summary.Changed > 0 {
+			result.changed = append(result.changed, summary.HostName)
+		}
+		if summary.Failures > 0 || summary.Dark > 0 {
+			result.failed = append(result.failed, summary.HostName)
+		}
+	}
+	return &result, nil
+}
+
+// problems lists the thresholds a gate result exceeds
+func (r *gateResult) problems(gate GateConfig) []string {
+	var problems []string
+	if len(r.changed) > gate.MaxChanged {
+		problems = append(problems, fmt.Sprintf("%d hosts would change (%s), at most %d allowed", len(r.changed), hostList(r.changed), gate.MaxChanged))
+	}
+	if len(r.failed) > gate.MaxFailures {
+		problems = append(problems, fmt.Sprintf("%d hosts failed (%s), at most %d allowed", len(r.failed), hostList(r.failed), gate.MaxFailures))
+	}
+	return problems
+}
+
+// hostList joins host names, listing no more than MaxTargetedMessageHosts
+func hostList(hosts []string) string {
+	if len(hosts) <= MaxTargetedMessageHosts {
+		return strings.Join(hosts, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(hosts[:MaxTargetedMessageHosts], ", "), len(hosts)-MaxTargetedMessageHosts)
+}
+
+// gateFailedMessage says what a failed gate means for the run, which depends
+// on whether the Run Task is mandatory or advisory
+func gateFailedMessage(request RunTaskRequest) string {
+	if request.TaskResultEnforcementLevel == MandatoryEnforcement {
+		return "this Run Task is mandatory, so the run cannot be applied"
+	}
+	return "this Run Task is advisory, so the run can still be applied"
+}
+
+// gatedRunTaskResponse waits for a check mode job and compares the hosts it
+// would change, and the hosts that failed, with the action's thresholds
+func gatedRunTaskResponse(action ActionConfig, request RunTaskRequest, message string, jobID int, detailsURL string, ansibleAuth *AnsibleAuthResponse) *RunTaskResponse {
+	running := createRunTaskResponse(Running, fmt.Sprintf("%s in check mode, waiting for it to finish", message), detailsURL)
+	tfcRunTaskResponse(running, request.TaskResultCallbackURL, request.AccessToken)
+
+	job, waitErr := ansibleWaitForJob(fmt.Sprintf("/api/v2/jobs/%d/", jobID), nil, ansibleAuth)
+	if waitErr != nil {
+		return actionRunTaskResponse(action, request, Failed, fmt.Sprintf("%s, but %s", message, waitErr), detailsURL)
+	}
+	outcomes := artifactOutcomes(job, action.Artifacts, detailsURL)
+
+	// a failed job may only be hosts failing, which the thresholds decide,
+	// but a job that errored or was canceled has no result to check
+	if job.Status != "successful" && job.Status != "failed" {
+		return withOutcomes(actionRunTaskResponse(action, request, Failed, fmt.Sprintf("%s, but %s", message, job.result()), detailsURL), outcomes)
+	}
+
+	result, resultErr := ansibleGateResultRequest(job, ansibleAuth)
+	if resultErr != nil {
+		return withOutcomes(actionRunTaskResponse(action, request, Failed, fmt.Sprintf("%s, but %s", message, resultErr), detailsURL), outcomes)
+	}
+
+	if problems := result.problems(action.Gate); len(problems) > 0 {
+		failure := fmt.Sprintf("Policy gate failed: %s; %s. %s", strings.Join(problems, "; "), gateFailedMessage(request), message)
+		return withOutcomes(actionRunTaskResponse(action, request, Failed, failure, detailsURL), outcomes)
+	}
+	passed := fmt.Sprintf("Policy gate passed: %d hosts would change and %d hosts failed, within the allowed %d and %d. %s", len(result.changed), len(result.failed), action.Gate.MaxChanged, action.Gate.MaxFailures, message)
+	return withOutcomes(actionRunTaskResponse(action, request, Passed, passed, detailsURL), outcomes)
+}
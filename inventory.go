@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	SmartInventory       = "smart"
+	ConstructedInventory = "constructed"
+)
+
+type AnsibleConstructedInventoryRequest struct {
+	Name         string `json:"name"`
+	Description  string `json:"description,omitempty"`
+	Organization int    `json:"organization"`
+	Variables    string `json:"variables,omitempty"`
+	SourceVars   string `json:"source_vars,omitempty"`
+	Limit        string `json:"limit,omitempty"`
+}
+
+// checkInventoryConfig checks that an action's inventory kind has the options
+// it needs
+func checkInventoryConfig(inventory InventoryConfig) error {
+	switch inventory.Kind {
+	case "":
+		if len(inventory.HostFilter) > 0 || len(inventory.InputInventories) > 0 {
+			return fmt.Errorf("inventory host_filter and input_inventories need a kind of %s or %s", SmartInventory, ConstructedInventory)
+		}
+	case SmartInventory:
+		if len(inventory.HostFilter) == 0 {
+			return fmt.Errorf("%s inventories need a host_filter", SmartInventory)
+		}
+	case ConstructedInventory:
+		if len(inventory.InputInventories) == 0 {
+			return fmt.Errorf("%s inventories need input_inventories", ConstructedInventory)
+		}
+	default:
+		return fmt.Errorf("inventory kind must be %s or %s, not %q", SmartInventory, ConstructedInventory, inventory.Kind)
+	}
+
+	if len(inventory.Sources) > 0 && len(inventory.Kind) > 0 {
+		return fmt.Errorf("%s inventories cannot have inventory sources", inventory.Kind)
+	}
+	if inventory.GroupTree.enabled() && len(inventory.Kind) > 0 {
+		return fmt.Errorf("%s inventories cannot have a group_tree", inventory.Kind)
+	}
+	if treeErr := checkGroupTreeConfig(inventory.GroupTree); treeErr != nil {
+		return treeErr
+	}
+	for _, source := range inventory.Sources {
+		if len(source.Source) == 0 {
+			return fmt.Errorf("inventory sources need a source, such as ec2 or azure_rm")
+		}
+	}
+
+	return nil
+}
+
+// constructedSourceVars renders the constructed inventory plugin options,
+// adding the action's groups and keyed_groups
+func constructedSourceVars(inventory InventoryConfig, data *TemplateData) (string, error) {
+	sourceVars := map[string]any{"plugin": "constructed"}
+	for key, value := range inventory.SourceVars {
+		sourceVars[key] = value
+	}
+	if len(inventory.Groups) > 0 {
+		sourceVars["groups"] = inventory.Groups
+	}
+	if len(inventory.KeyedGroups) > 0 {
+		sourceVars["keyed_groups"] = inventory.KeyedGroups
+	}
+
+	rendered, renderErr := renderValues("source_vars", sourceVars, data)
+	if renderErr != nil {
+		return "", renderErr
+	}
+
+	encoded, yamlErr := yaml.Marshal(rendered)
+	if yamlErr != nil {
+		return "", yamlErr
+	}
+	return string(encoded), nil
+}
+
+// ansibleCreateConstructedInventoryRequest creates a constructed inventory and
+// adds its input inventories, in the order they are configured
+func ansibleCreateConstructedInventoryRequest(data *TemplateData, name string, description string, variables string, organisation int, action ActionConfig, ansibleAuth *AnsibleAuthResponse) (*AnsibleInventoryResponse, error) {
+	var inputs []int
+	for _, input := range action.Inventory.InputInventories {
+		id, inputErr := resolveInventory(input, data, action, ansibleAuth)
+		if inputErr != nil {
+			return nil, inputErr
+		}
+		if id != 0 {
+			inputs = append(inputs, id)
+		}
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("no input inventories to construct Ansible Inventory %s from", name)
+	}
+
+	sourceVars, sourceVarsErr := constructedSourceVars(action.Inventory, data)
+	if sourceVarsErr != nil {
+		return nil, sourceVarsErr
+	}
+
+	limit, limitErr := resolveActionValue("inventory limit", action.Inventory.Limit, data)
+	if limitErr != nil {
+		return nil, limitErr
+	}
+
+	var constructedReq AnsibleConstructedInventoryRequest
+	constructedReq.Name = name
+	constructedReq.Description = description
+	constructedReq.Organization = organisation
+	constructedReq.Variables = variables
+	constructedReq.SourceVars = sourceVars
+	constructedReq.Limit = limit
+
+	var inventory AnsibleInventoryResponse
+	if err := ansibleAPIRequest(ansibleAuth, http.MethodPost, "/api/v2/constructed_inventories/", constructedReq, &inventory); err != nil {
+		return nil, err
+	}
+
+	for _, input := range inputs {
+		path := fmt.Sprintf("/api/v2/constructed_inventories/%d/input_inventories/", inventory.ID)
+		if err := ansibleAPIRequest(ansibleAuth, http.MethodPost, path, AnsibleAssociateRequest{ID: input}, nil); err != nil {
+			inputErr := fmt.Errorf("unable to add input inventory %d to Ansible Inventory %s: %w", input, inventory.Name, err)
+			// remove the partly built inventory, as a retry cannot create
+			// another with the same name
+			if deleteErr := ansibleAPIRequest(ansibleAuth, http.MethodDelete, fmt.Sprintf("/api/v2/inventories/%d/", inventory.ID), nil, nil); deleteErr != nil {
+				return nil, fmt.Errorf("%w, and it could not be removed: %s", inputErr, deleteErr)
+			}
+			return nil, inputErr
+		}
+	}
+
+	return &inventory, nil
+}
+
+// inventoryKindMessage describes a smart or constructed inventory
+func inventoryKindMessage(inventory *AnsibleInventoryResponse) string {
+	switch inventory.Kind {
+	case SmartInventory:
+		return fmt.Sprintf(" (%s, host filter %v)", inventory.Kind, inventory.HostFilter)
+	case ConstructedInventory:
+		return fmt.Sprintf(" (%s)", inventory.Kind)
+	default:
+		return ""
+	}
+}
+
+// inventoryDetailsURL links to an inventory in the controller UI, which has
+// a different route for each kind of inventory
+func inventoryDetailsURL(inventory *AnsibleInventoryResponse) string {
+	route := "inventory"
+	if len(inventory.Kind) > 0 {
+		route = fmt.Sprintf("%s_inventory", inventory.Kind)
+	}
+	return fmt.Sprintf("%s/#/inventories/%s/%d/details", ansibleHost, route, inventory.ID)
+}
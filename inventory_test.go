@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestConstructedInventoryIsRemovedWhenAnInputFails(t *testing.T) {
+	var deleted string
+	auth := newTestController(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v2/constructed_inventories/":
+			w.Write([]byte(`{"id": 8, "name": "web-prod", "kind": "constructed"}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v2/constructed_inventories/8/input_inventories/":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"detail": "Inventory 4 does not exist."}`))
+		case r.Method == http.MethodDelete:
+			deleted = r.URL.Path
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	action := ActionConfig{Inventory: InventoryConfig{Kind: ConstructedInventory, InputInventories: []string{"3", "4"}}}
+	inventory, err := ansibleCreateConstructedInventoryRequest(newTemplateData(RunTaskRequest{}), "web-prod", "", "", 1, action, auth)
+	var apiErr *AnsibleAPIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected the input inventory error, got %v", err)
+	}
+	if inventory != nil {
+		t.Errorf("expected no inventory, got %+v", inventory)
+	}
+	if deleted != "/api/v2/inventories/8/" {
+		t.Errorf("expected the constructed inventory to be removed, removed %q", deleted)
+	}
+}
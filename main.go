@@ -5,12 +5,10 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -69,6 +67,7 @@ type RunTaskResponse struct {
 			Message string `json:"message,omitempty"`
 			URL     string `json:"url,omitempty"`
 		} `json:"attributes"`
+		Relationships *RunTaskRelationships `json:"relationships,omitempty"`
 	} `json:"data"`
 }
 
@@ -82,27 +81,38 @@ type HMACResponse struct {
 }
 
 type AnsibleJobTemplateRequest struct {
-	Inventory string         `json:"inventory,omitempty"`
-	ExtraVars map[string]any `json:"extra_vars,omitempty"`
-}
-
-type AnsibleWorkflowJobTemplateRequest struct {
-	AskLimitOnLaunch     bool           `json:"ask_limit_on_launch,omitempty"`
-	AskScmBranchOnLaunch bool           `json:"ask_scm_branch_on_launch,omitempty"`
-	ExtraVars            map[string]any `json:"extra_vars,omitempty"`
 	Inventory            int            `json:"inventory,omitempty"`
+	ExtraVars            map[string]any `json:"extra_vars,omitempty"`
 	Limit                string         `json:"limit,omitempty"`
 	ScmBranch            string         `json:"scm_branch,omitempty"`
+	JobTags              string         `json:"job_tags,omitempty"`
+	SkipTags             string         `json:"skip_tags,omitempty"`
+	JobType              string         `json:"job_type,omitempty"`
+	Verbosity            *int           `json:"verbosity,omitempty"`
+	DiffMode             *bool          `json:"diff_mode,omitempty"`
+	Credentials          []int          `json:"credentials,omitempty"`
+	ExecutionEnvironment int            `json:"execution_environment,omitempty"`
+	InstanceGroups       []int          `json:"instance_groups,omitempty"`
+	Labels               []int          `json:"labels,omitempty"`
+}
+
+type AnsibleWorkflowJobTemplateRequest struct {
+	ExtraVars map[string]any `json:"extra_vars,omitempty"`
+	Inventory int            `json:"inventory,omitempty"`
+	Limit     string         `json:"limit,omitempty"`
+	ScmBranch string         `json:"scm_branch,omitempty"`
+	JobTags   string         `json:"job_tags,omitempty"`
+	SkipTags  string         `json:"skip_tags,omitempty"`
+	Labels    []int          `json:"labels,omitempty"`
 }
 
 type AnsibleJobTemplateResponse struct {
-	Job           int `json:"job,omitempty"`
-	IgnoredFields struct {
-	} `json:"ignored_fields,omitempty"`
-	ID      int    `json:"id,omitempty"`
-	Type    string `json:"type,omitempty"`
-	URL     string `json:"url,omitempty"`
-	Related struct {
+	Job           int            `json:"job,omitempty"`
+	IgnoredFields map[string]any `json:"ignored_fields,omitempty"`
+	ID            int            `json:"id,omitempty"`
+	Type          string         `json:"type,omitempty"`
+	URL           string         `json:"url,omitempty"`
+	Related       struct {
 		CreatedBy          string `json:"created_by,omitempty"`
 		ModifiedBy         string `json:"modified_by,omitempty"`
 		Labels             string `json:"labels,omitempty"`
@@ -232,30 +242,28 @@ type AnsibleJobTemplateResponse struct {
 		Type string `json:"type,omitempty"`
 		URL  string `json:"url,omitempty"`
 	} `json:"launched_by,omitempty"`
-	WorkUnitID             any   `json:"work_unit_id,omitempty"`
-	JobTemplate            int   `json:"job_template,omitempty"`
-	PasswordsNeededToStart []any `json:"passwords_needed_to_start,omitempty"`
-	AllowSimultaneous      bool  `json:"allow_simultaneous,omitempty"`
-	Artifacts              struct {
-	} `json:"artifacts,omitempty"`
-	ScmRevision       string `json:"scm_revision,omitempty"`
-	InstanceGroup     any    `json:"instance_group,omitempty"`
-	DiffMode          bool   `json:"diff_mode,omitempty"`
-	JobSliceNumber    int    `json:"job_slice_number,omitempty"`
-	JobSliceCount     int    `json:"job_slice_count,omitempty"`
-	WebhookService    string `json:"webhook_service,omitempty"`
-	WebhookCredential any    `json:"webhook_credential,omitempty"`
-	WebhookGUID       string `json:"webhook_guid,omitempty"`
+	WorkUnitID             any            `json:"work_unit_id,omitempty"`
+	JobTemplate            int            `json:"job_template,omitempty"`
+	PasswordsNeededToStart []any          `json:"passwords_needed_to_start,omitempty"`
+	AllowSimultaneous      bool           `json:"allow_simultaneous,omitempty"`
+	Artifacts              map[string]any `json:"artifacts,omitempty"`
+	ScmRevision            string         `json:"scm_revision,omitempty"`
+	InstanceGroup          any            `json:"instance_group,omitempty"`
+	DiffMode               bool           `json:"diff_mode,omitempty"`
+	JobSliceNumber         int            `json:"job_slice_number,omitempty"`
+	JobSliceCount          int            `json:"job_slice_count,omitempty"`
+	WebhookService         string         `json:"webhook_service,omitempty"`
+	WebhookCredential      any            `json:"webhook_credential,omitempty"`
+	WebhookGUID            string         `json:"webhook_guid,omitempty"`
 }
 
 type AnsibleWorkflowJobTemplateResponse struct {
-	WorkflowJob   int `json:"workflow_job,omitempty"`
-	IgnoredFields struct {
-	} `json:"ignored_fields,omitempty"`
-	ID      int    `json:"id,omitempty"`
-	Type    string `json:"type,omitempty"`
-	URL     string `json:"url,omitempty"`
-	Related struct {
+	WorkflowJob   int            `json:"workflow_job,omitempty"`
+	IgnoredFields map[string]any `json:"ignored_fields,omitempty"`
+	ID            int            `json:"id,omitempty"`
+	Type          string         `json:"type,omitempty"`
+	URL           string         `json:"url,omitempty"`
+	Related       struct {
 		CreatedBy           string `json:"created_by,omitempty"`
 		ModifiedBy          string `json:"modified_by,omitempty"`
 		UnifiedJobTemplate  string `json:"unified_job_template,omitempty"`
@@ -359,15 +367,13 @@ type AnsibleWorkflowJobTemplateResponse struct {
 	JobTags             any    `json:"job_tags,omitempty"`
 }
 
-type AnsibleBasicResponse struct {
-	All []string `json:"__all__,omitempty"`
-}
-
 type AnsibleInventoryRequest struct {
 	HostFilter   string `json:"host_filter"`
 	Kind         string `json:"kind"`
 	Name         string `json:"name"`
+	Description  string `json:"description,omitempty"`
 	Organization int    `json:"organization"`
+	Variables    string `json:"variables,omitempty"`
 }
 
 type AnsibleInventoryResponse struct {
@@ -547,8 +553,15 @@ func createRunTaskResponse(status string, message string, detailsUrl string) *Ru
 }
 
 func tfcRunTaskResponse(runTaskResponse *RunTaskResponse, uri string, token string) {
-	client := &http.Client{
-		Timeout: time.Second * 10,
+	if urlErr := validateTFCURL(uri); urlErr != nil {
+		log.Printf("SECURITY: refused to send Run Task result to %q: %s", uri, urlErr)
+		return
+	}
+
+	// never follow a redirect away from the validated callback host
+	client := *tfcClient
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
 	}
 
 	jsonResponse, jsonErr := json.Marshal(runTaskResponse)
@@ -570,38 +583,23 @@ func tfcRunTaskResponse(runTaskResponse *RunTaskResponse, uri string, token stri
 
 	if respErr != nil {
 		log.Print(respErr.Error())
+		return
 	}
 	defer response.Body.Close()
 }
 
 func ansibleTokenRequest() (*AnsibleAuthResponse, error) {
-	client := &http.Client{
-		Timeout: time.Second * 10,
-	}
-
-	req, reqErr := http.NewRequest("POST", fmt.Sprintf("%s/%s", ansibleHost, "/api/v2/tokens/"), nil)
-
+	req, reqErr := http.NewRequest(http.MethodPost, ansibleHost+"/api/v2/tokens/", nil)
 	if reqErr != nil {
-		return nil, fmt.Errorf(reqErr.Error())
+		return nil, reqErr
 	}
 
+	req.Header.Set("Content-Type", "application/json")
 	req.SetBasicAuth(ansibleUser, ansiblePassword)
 
-	response, respErr := client.Do(req)
-	if respErr != nil {
-		return nil, fmt.Errorf(respErr.Error())
-	}
-
-	body, bodyErr := io.ReadAll(response.Body)
-	if bodyErr != nil {
-		return nil, fmt.Errorf(bodyErr.Error())
-	}
-	defer response.Body.Close()
-
 	var authResponse AnsibleAuthResponse
-	bindErr := json.Unmarshal(body, &authResponse)
-	if bindErr != nil {
-		return nil, fmt.Errorf(bindErr.Error())
+	if err := ansibleDo(req, &authResponse); err != nil {
+		return nil, fmt.Errorf("unable to request an Ansible Token: %w", err)
 	}
 
 	log.Printf("Sucessfully requested Ansible Token %d", authResponse.ID)
@@ -610,231 +608,438 @@ func ansibleTokenRequest() (*AnsibleAuthResponse, error) {
 }
 
 func ansibleTokenRevoke(authResponse *AnsibleAuthResponse) error {
-	client := &http.Client{
-		Timeout: time.Second * 10,
-	}
-
-	req, reqErr := http.NewRequest("DELETE", fmt.Sprintf("%s/%s/%d/", ansibleHost, "/api/v2/tokens/", authResponse.ID), nil)
-
-	if reqErr != nil {
-		return fmt.Errorf(reqErr.Error())
-	}
-
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", authResponse.Token))
-	response, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf(err.Error())
+	if err := ansibleAPIRequest(authResponse, http.MethodDelete, fmt.Sprintf("/api/v2/tokens/%d/", authResponse.ID), nil, nil); err != nil {
+		log.Printf("Unable to revoke Ansible Token %d: %s", authResponse.ID, err)
+		return err
 	}
-	defer response.Body.Close()
 
 	log.Printf("Sucessfully revoked Ansible Token %d", authResponse.ID)
 
 	return nil
 }
 
-func ansibleCreateInventoryRequest(request RunTaskRequest, organisation int, ansibleAuth *AnsibleAuthResponse) (*AnsibleInventoryResponse, error) {
-	client := &http.Client{
-		Timeout: time.Second * 10,
-	}
-
-	var inventoryReq AnsibleInventoryRequest
-	inventoryReq.Kind = ""
-	inventoryReq.Name = request.WorkspaceName
-	inventoryReq.Organization = organisation
-	inventoryReq.HostFilter = ""
-
-	jsonResponse, jsonErr := json.Marshal(inventoryReq)
+func ansibleCreateInventoryRequest(request RunTaskRequest, organisation int, action ActionConfig, ansibleAuth *AnsibleAuthResponse) (*AnsibleInventoryResponse, error) {
+	data := newTemplateData(request)
 
-	if jsonErr != nil {
-		return nil, fmt.Errorf(jsonErr.Error())
+	name, nameErr := inventoryName(data, action)
+	if nameErr != nil {
+		return nil, nameErr
 	}
 
-	req, reqErr := http.NewRequest("POST", fmt.Sprintf("%s/%s", ansibleHost, "/api/v2/inventories/"), bytes.NewBuffer(jsonResponse))
+	description, descriptionErr := renderTemplate("inventory description", action.Inventory.Description, data)
+	if descriptionErr != nil {
+		return nil, descriptionErr
+	}
 
-	if reqErr != nil {
-		return nil, fmt.Errorf(reqErr.Error())
+	variables, variablesErr := inventoryVariables(action.Inventory.Variables, data)
+	if variablesErr != nil {
+		return nil, variablesErr
+	}
+	encodedVariables, encodeErr := encodeInventoryVariables(variables)
+	if encodeErr != nil {
+		return nil, encodeErr
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ansibleAuth.Token))
+	if action.Inventory.Kind == ConstructedInventory {
+		return ansibleCreateConstructedInventoryRequest(data, name, description, encodedVariables, organisation, action, ansibleAuth)
+	}
 
-	response, respErr := client.Do(req)
-	if respErr != nil {
-		return nil, fmt.Errorf(respErr.Error())
+	hostFilter, hostFilterErr := resolveActionValue("inventory host_filter", action.Inventory.HostFilter, data)
+	if hostFilterErr != nil {
+		return nil, hostFilterErr
 	}
 
-	body, bodyErr := io.ReadAll(response.Body)
-	if reqErr != nil {
-		return nil, fmt.Errorf(bodyErr.Error())
+	var inventoryReq AnsibleInventoryRequest
+	inventoryReq.Kind = action.Inventory.Kind
+	inventoryReq.Name = name
+	inventoryReq.Description = description
+	inventoryReq.Organization = organisation
+	inventoryReq.HostFilter = hostFilter
+	inventoryReq.Variables = encodedVariables
+
+	// the group tree is built first, so that an error in it leaves no
+	// inventory behind. A new inventory has no hosts yet, they are added to
+	// the groups when it is synchronised
+	var groups map[string]*desiredGroup
+	if action.Inventory.GroupTree.enabled() {
+		var treeErr error
+		groups, treeErr = groupTree(request, action.Inventory.GroupTree, nil)
+		if treeErr != nil {
+			return nil, treeErr
+		}
 	}
-	defer response.Body.Close()
 
-	// Not sure which one of these we will get back
 	var invResponse AnsibleInventoryResponse
-	var basicResponse AnsibleBasicResponse
-
-	bindErr := json.Unmarshal(body, &invResponse)
-	bindErrBasic := json.Unmarshal(body, &basicResponse)
-	if bindErr != nil {
-		return nil, fmt.Errorf(bindErr.Error())
-	}
-	if bindErrBasic != nil {
-		return nil, fmt.Errorf(bindErrBasic.Error())
+	if err := ansibleAPIRequest(ansibleAuth, http.MethodPost, "/api/v2/inventories/", inventoryReq, &invResponse); err != nil {
+		return nil, fmt.Errorf("unable to create Ansible Inventory %s: %w", name, err)
 	}
 
-	if invResponse.ID == 0 && basicResponse.All != nil {
-		var sb strings.Builder
-		for _, str := range basicResponse.All {
-			sb.WriteString(str)
+	if len(groups) > 0 {
+		if groupsErr := reconcileGroups(invResponse.ID, groups, map[string]int{}, &reconcileSummary{}, ansibleAuth); groupsErr != nil {
+			return &invResponse, groupsErr
 		}
-		return nil, fmt.Errorf(sb.String())
 	}
 
 	return &invResponse, nil
 }
 
-func ansibleJobTemplateRequest(request RunTaskRequest, jobTemplateId string, ansibleAuth *AnsibleAuthResponse) (*AnsibleJobTemplateResponse, error) {
-	client := &http.Client{
-		Timeout: time.Second * 10,
+func ansibleJobTemplateRequest(request RunTaskRequest, jobTemplateId string, action ActionConfig, ansibleAuth *AnsibleAuthResponse) (*AnsibleJobTemplateResponse, error) {
+	data := newTemplateData(request)
+
+	jtReq, jtReqErr := buildJobTemplateRequest(data, action, ansibleAuth)
+	if jtReqErr != nil {
+		return nil, jtReqErr
 	}
 
-	var jtReq AnsibleJobTemplateRequest
-	jsonResponse, jsonErr := json.Marshal(jtReq)
+	requirements, reqsErr := ansibleLaunchRequirementsRequest(fmt.Sprintf("/api/v2/job_templates/%s/launch/", jobTemplateId), ansibleAuth)
+	if reqsErr != nil {
+		return nil, reqsErr
+	}
 
-	if jsonErr != nil {
-		return nil, fmt.Errorf(jsonErr.Error())
+	answers, surveyErr := surveyAnswers(data, action.Survey, requirements.SurveyEnabled, fmt.Sprintf("/api/v2/job_templates/%s/survey_spec/", jobTemplateId), ansibleAuth)
+	if surveyErr != nil {
+		return nil, surveyErr
 	}
+	jtReq.ExtraVars = mergeExtraVars(jtReq.ExtraVars, answers)
 
-	req, reqErr := http.NewRequest("POST", fmt.Sprintf("%s/%s/%s/%s/", ansibleHost, "/api/v2/job_templates/", jobTemplateId, "launch"), bytes.NewBuffer(jsonResponse))
+	if checkErr := checkJobTemplateLaunch(requirements, jtReq); checkErr != nil {
+		return nil, checkErr
+	}
 
-	if reqErr != nil {
-		return nil, fmt.Errorf(reqErr.Error())
+	var jtResponse AnsibleJobTemplateResponse
+	if err := ansibleAPIRequest(ansibleAuth, http.MethodPost, fmt.Sprintf("/api/v2/job_templates/%s/launch/", jobTemplateId), jtReq, &jtResponse); err != nil {
+		return nil, fmt.Errorf("unable to launch Ansible Job Template %s: %w", jobTemplateId, err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ansibleAuth.Token))
+	return &jtResponse, nil
+}
 
-	response, respErr := client.Do(req)
-	if respErr != nil {
-		return nil, fmt.Errorf(respErr.Error())
+func ansibleWorkflowJobTemplateRequest(request RunTaskRequest, workflowTemplateId string, action ActionConfig, ansibleAuth *AnsibleAuthResponse) (*AnsibleWorkflowJobTemplateResponse, error) {
+	data := newTemplateData(request)
+
+	wfjtReq, wfjtReqErr := buildWorkflowJobTemplateRequest(data, action, ansibleAuth)
+	if wfjtReqErr != nil {
+		return nil, wfjtReqErr
 	}
 
-	body, bodyErr := io.ReadAll(response.Body)
-	if bodyErr != nil {
-		return nil, fmt.Errorf(bodyErr.Error())
+	requirements, reqsErr := ansibleLaunchRequirementsRequest(fmt.Sprintf("/api/v2/workflow_job_templates/%s/launch/", workflowTemplateId), ansibleAuth)
+	if reqsErr != nil {
+		return nil, reqsErr
 	}
 
-	var jtResponse AnsibleJobTemplateResponse
-	bindErr := json.Unmarshal(body, &jtResponse)
-	if bindErr != nil {
-		return nil, fmt.Errorf(bindErr.Error())
+	answers, surveyErr := surveyAnswers(data, action.Survey, requirements.SurveyEnabled, fmt.Sprintf("/api/v2/workflow_job_templates/%s/survey_spec/", workflowTemplateId), ansibleAuth)
+	if surveyErr != nil {
+		return nil, surveyErr
 	}
+	wfjtReq.ExtraVars = mergeExtraVars(wfjtReq.ExtraVars, answers)
 
-	defer response.Body.Close()
+	if checkErr := checkWorkflowJobTemplateLaunch(requirements, wfjtReq); checkErr != nil {
+		return nil, checkErr
+	}
 
-	return &jtResponse, nil
+	var wfjtResponse AnsibleWorkflowJobTemplateResponse
+	if err := ansibleAPIRequest(ansibleAuth, http.MethodPost, fmt.Sprintf("/api/v2/workflow_job_templates/%s/launch/", workflowTemplateId), wfjtReq, &wfjtResponse); err != nil {
+		return nil, fmt.Errorf("unable to launch Ansible Workflow Job Template %s: %w", workflowTemplateId, err)
+	}
+
+	return &wfjtResponse, nil
 }
 
-func ansibleWorkflowJobTemplateRequest(request RunTaskRequest, workflowTemplateId string, ansibleAuth *AnsibleAuthResponse) (*AnsibleWorkflowJobTemplateResponse, error) {
-	client := &http.Client{
-		Timeout: time.Second * 10,
-	}
+// runTaskEndpoint describes what an endpoint does, for Run Task messages and
+// concurrency keys
+type runTaskEndpoint struct {
+	skipped  string
+	template string
+}
 
-	var wftjtReq AnsibleWorkflowJobTemplateRequest
-	jsonResponse, jsonErr := json.Marshal(wftjtReq)
+var runTaskEndpoints = map[string]runTaskEndpoint{
+	JobEndpoint:       {skipped: "launching Ansible Job Template", template: "Job Template"},
+	WorkflowEndpoint:  {skipped: "launching Ansible Workflow Job Template", template: "Workflow Job Template"},
+	AdHocEndpoint:     {skipped: "launching Ansible Ad Hoc Command", template: "Inventory"},
+	InventoryEndpoint: {skipped: "creating or synchronising the Ansible Inventory", template: "Organisation"},
+}
 
-	if jsonErr != nil {
-		return nil, fmt.Errorf(jsonErr.Error())
+// acknowledgeRunTask responds to TFC/TFE, then processes the Run Task with the
+// action named in its URL. Launching, syncing and waiting for jobs can take
+// longer than TFC/TFE waits for a response, so the Run Task is processed once
+// it has been acknowledged
+func acknowledgeRunTask(c *gin.Context, runTask RunTaskRequest, endpoint string, process func(ActionConfig)) {
+	c.Status(http.StatusOK)
+	// if this isn't a test, send the ackowledgement that we've had the request
+	if runTask.AccessToken == TestToken {
+		return
 	}
 
-	req, reqErr := http.NewRequest("POST", fmt.Sprintf("%s/%s/%s/%s/", ansibleHost, "/api/v2/workflow_job_templates/", workflowTemplateId, "launch"), bytes.NewBuffer(jsonResponse))
-
-	if reqErr != nil {
-		return nil, fmt.Errorf(reqErr.Error())
+	action, actionErr := actionForRequest(c, endpoint)
+	if actionErr != nil {
+		errResponse := createRunTaskResponse(Failed, actionErr.Error(), "")
+		tfcRunTaskResponse(errResponse, runTask.TaskResultCallbackURL, runTask.AccessToken)
+		releaseRunTask()
+		return
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ansibleAuth.Token))
+	go process(action)
+}
 
-	response, respErr := client.Do(req)
-	if respErr != nil {
-		return nil, fmt.Errorf(respErr.Error())
+// preparedRunTask is a Run Task that holds its concurrency slots and a
+// controller token
+type preparedRunTask struct {
+	action  ActionConfig
+	auth    *AnsibleAuthResponse
+	release func()
+}
+
+// finish revokes the token and frees the concurrency slots
+func (p *preparedRunTask) finish() {
+	ansibleTokenRevoke(p.auth)
+	p.release()
+}
+
+// prepareRunTask applies the action's speculative policy, waits for its
+// concurrency slots and requests a controller token. If the Run Task should
+// go no further, the response to send instead is returned
+func prepareRunTask(runTask RunTaskRequest, action ActionConfig, endpoint string, template string) (*preparedRunTask, *RunTaskResponse) {
+	if runTask.IsSpeculative {
+		if speculativeErr := checkSpeculativeConfig(action.Speculative, endpoint); speculativeErr != nil {
+			return nil, actionRunTaskResponse(action, runTask, Failed, speculativeErr.Error(), "")
+		}
+	}
+	if speculativePolicy(runTask, action) == SpeculativeSkip {
+		return nil, actionRunTaskResponse(action, runTask, Passed, speculativeSkippedMessage(runTaskEndpoints[endpoint].skipped), "")
 	}
+	action = speculativeAction(runTask, action)
 
-	body, bodyErr := io.ReadAll(response.Body)
-	if bodyErr != nil {
-		return nil, fmt.Errorf(bodyErr.Error())
+	release, queueErr := acquireConcurrency(runTask, action, runTaskEndpoints[endpoint].template, template)
+	if queueErr != nil {
+		return nil, actionRunTaskResponse(action, runTask, Failed, queueErr.Error(), "")
 	}
 
-	var wfjtResponse AnsibleWorkflowJobTemplateResponse
-	bindErr := json.Unmarshal(body, &wfjtResponse)
-	if bindErr != nil {
-		return nil, fmt.Errorf(bindErr.Error())
+	ansibleAuthResponse, tokErr := ansibleTokenRequest()
+	if tokErr != nil {
+		release()
+		return nil, tokenFailedResponse(runTask, tokErr)
 	}
 
-	defer response.Body.Close()
+	return &preparedRunTask{action: action, auth: ansibleAuthResponse, release: release}, nil
+}
 
-	return &wfjtResponse, nil
+// targetRunTask limits a launch to the hosts changed by the plan, if the
+// action targets them. If nothing should be launched, the response to send
+// instead is returned
+func targetRunTask(runTask RunTaskRequest, action ActionConfig, endpoint string) (ActionConfig, []string, *RunTaskResponse) {
+	if !action.Target.enabled() {
+		return action, nil, nil
+	}
+
+	targetHosts, targetErr := planTargetHosts(runTask, action.Target)
+	if targetErr != nil {
+		return action, nil, actionRunTaskResponse(action, runTask, Failed, targetErr.Error(), "")
+	}
+	if len(targetHosts) == 0 {
+		return action, nil, actionRunTaskResponse(action, runTask, Passed, fmt.Sprintf("No targeted hosts are changed by the plan, skipped %s", runTaskEndpoints[endpoint].skipped), "")
+	}
+	return targetedAction(action, targetHosts), targetHosts, nil
 }
 
 func handleJobTemplateRunTask(c *gin.Context) {
 	var runTask = parseRunTaskPayload(c)
+	if !validateRunTaskCallback(c, runTask) {
+		return
+	}
+	if !reserveRunTask(c, runTask) {
+		return
+	}
 	jobTemplateId := c.Param("jobTemplateId")
 
 	log.Printf("Run Task event received for Job Template ID %s", jobTemplateId)
 
-	c.Status(http.StatusOK)
-	// if this isn't a test, send the ackowledgement that we've had the request
-	if runTask.AccessToken != TestToken {
-		var ansibleAuthResponse, tokErr = ansibleTokenRequest()
-		if tokErr != nil {
-			errResponse := createRunTaskResponse(Failed, tokErr.Error(), "")
-			tfcRunTaskResponse(errResponse, runTask.TaskResultCallbackURL, runTask.AccessToken)
-		}
+	acknowledgeRunTask(c, runTask, JobEndpoint, func(action ActionConfig) {
+		processJobTemplateRunTask(runTask, jobTemplateId, action)
+	})
+}
+
+// processJobTemplateRunTask does the work of the job endpoint, sending the
+// result to the Run Task callback URL
+func processJobTemplateRunTask(runTask RunTaskRequest, jobTemplateId string, action ActionConfig) {
+	defer releaseRunTask()
+
+	prepared, response := prepareRunTask(runTask, action, JobEndpoint, jobTemplateId)
+	if response != nil {
+		tfcRunTaskResponse(response, runTask.TaskResultCallbackURL, runTask.AccessToken)
+		return
+	}
+	defer prepared.finish()
+	ansibleAuthResponse := prepared.auth
+
+	action, targetHosts, response := targetRunTask(runTask, prepared.action, JobEndpoint)
+	if response != nil {
+		tfcRunTaskResponse(response, runTask.TaskResultCallbackURL, runTask.AccessToken)
+		return
+	}
+	if action.Gate.Enabled {
+		action = gateAction(action)
+	}
+
+	var project *AnsibleProject
+	var scmBranch string
+	if action.ProjectSync.Enabled {
+		running := createRunTaskResponse(Running, "Syncing the Job Template's project before launch", "")
+		tfcRunTaskResponse(running, runTask.TaskResultCallbackURL, runTask.AccessToken)
 
-		var jobTemplateResponse, jtErr = ansibleJobTemplateRequest(runTask, jobTemplateId, ansibleAuthResponse)
-		if jtErr != nil {
-			errResponse := createRunTaskResponse(Failed, jtErr.Error(), "")
+		var syncErr error
+		project, scmBranch, syncErr = ansibleSyncProjectRequest(runTask, jobTemplateId, action, ansibleAuthResponse)
+		if syncErr != nil {
+			errResponse := actionRunTaskResponse(action, runTask, Failed, syncErr.Error(), "")
 			tfcRunTaskResponse(errResponse, runTask.TaskResultCallbackURL, runTask.AccessToken)
-		} else {
-			response := createRunTaskResponse(Passed, fmt.Sprintf("Succesfully triggered Ansible Job Template, %s", jobTemplateResponse.Name), fmt.Sprintf("%s/#/jobs/playbook/%d/output", ansibleHost, jobTemplateResponse.ID))
-			tfcRunTaskResponse(response, runTask.TaskResultCallbackURL, runTask.AccessToken)
+			return
 		}
-		ansibleTokenRevoke(ansibleAuthResponse)
+		// the branch comes from the VCS, so it is not rendered as a template
+		if len(scmBranch) > 0 {
+			action.Launch.setLiteral("scm_branch", scmBranch)
+		}
+	}
+
+	var jobTemplateResponse, jtErr = ansibleJobTemplateRequest(runTask, jobTemplateId, action, ansibleAuthResponse)
+	if jtErr != nil {
+		errResponse := actionRunTaskResponse(action, runTask, Failed, jtErr.Error(), "")
+		tfcRunTaskResponse(errResponse, runTask.TaskResultCallbackURL, runTask.AccessToken)
+		return
 	}
 
+	message := fmt.Sprintf("Succesfully triggered Ansible Job Template, %s%s%s%s%s", jobTemplateResponse.Name, speculativeMessage(runTask, action), projectSyncMessage(project, scmBranch), targetedMessage(action, targetHosts), ignoredFieldsMessage(jobTemplateResponse.IgnoredFields))
+	detailsURL := fmt.Sprintf("%s/#/jobs/playbook/%d/output", ansibleHost, jobTemplateResponse.ID)
+	response = actionRunTaskResponse(action, runTask, Passed, message, detailsURL)
+	if action.Gate.Enabled {
+		response = gatedRunTaskResponse(action, runTask, message, jobTemplateResponse.ID, detailsURL, ansibleAuthResponse)
+	} else if action.Wait {
+		response = waitedRunTaskResponse(action, runTask, message, fmt.Sprintf("/api/v2/jobs/%d/", jobTemplateResponse.ID), detailsURL, ansibleAuthResponse)
+	}
+	tfcRunTaskResponse(response, runTask.TaskResultCallbackURL, runTask.AccessToken)
 }
 
 func handleWorkflowJobTemplateRunTask(c *gin.Context) {
 	var runTask = parseRunTaskPayload(c)
+	if !validateRunTaskCallback(c, runTask) {
+		return
+	}
+	if !reserveRunTask(c, runTask) {
+		return
+	}
 	workflowTemplateId := c.Param("workflowTemplateId")
 
 	log.Printf("Run Task event received for Workflow Template ID %s", workflowTemplateId)
 
-	c.Status(http.StatusOK)
-	// if this isn't a test, send the ackowledgement that we've had the request
-	if runTask.AccessToken != TestToken {
-		var ansibleAuthResponse, tokErr = ansibleTokenRequest()
-		if tokErr != nil {
-			errResponse := createRunTaskResponse(Failed, tokErr.Error(), "")
-			tfcRunTaskResponse(errResponse, runTask.TaskResultCallbackURL, runTask.AccessToken)
-		}
+	acknowledgeRunTask(c, runTask, WorkflowEndpoint, func(action ActionConfig) {
+		processWorkflowJobTemplateRunTask(runTask, workflowTemplateId, action)
+	})
+}
+
+// processWorkflowJobTemplateRunTask does the work of the workflow endpoint,
+// sending the result to the Run Task callback URL
+func processWorkflowJobTemplateRunTask(runTask RunTaskRequest, workflowTemplateId string, action ActionConfig) {
+	defer releaseRunTask()
+
+	prepared, response := prepareRunTask(runTask, action, WorkflowEndpoint, workflowTemplateId)
+	if response != nil {
+		tfcRunTaskResponse(response, runTask.TaskResultCallbackURL, runTask.AccessToken)
+		return
+	}
+	defer prepared.finish()
+	ansibleAuthResponse := prepared.auth
+
+	action, targetHosts, response := targetRunTask(runTask, prepared.action, WorkflowEndpoint)
+	if response != nil {
+		tfcRunTaskResponse(response, runTask.TaskResultCallbackURL, runTask.AccessToken)
+		return
+	}
+
+	var workflowJobTemplateResponse, wfjtErr = ansibleWorkflowJobTemplateRequest(runTask, workflowTemplateId, action, ansibleAuthResponse)
+	if wfjtErr != nil {
+		errResponse := actionRunTaskResponse(action, runTask, Failed, wfjtErr.Error(), "")
+		tfcRunTaskResponse(errResponse, runTask.TaskResultCallbackURL, runTask.AccessToken)
+		return
+	}
+
+	message := fmt.Sprintf("Succesfully triggered Ansible Workflow Job Template, %s%s%s%s", workflowJobTemplateResponse.Name, speculativeMessage(runTask, action), targetedMessage(action, targetHosts), ignoredFieldsMessage(workflowJobTemplateResponse.IgnoredFields))
+	detailsURL := fmt.Sprintf("%s/#/jobs/workflow/%d/output", ansibleHost, workflowJobTemplateResponse.ID)
+	response = actionRunTaskResponse(action, runTask, Passed, message, detailsURL)
+	if action.Wait {
+		response = waitedWorkflowRunTaskResponse(action, runTask, message, workflowJobTemplateResponse.ID, detailsURL, ansibleAuthResponse)
+	}
+	tfcRunTaskResponse(response, runTask.TaskResultCallbackURL, runTask.AccessToken)
+}
+
+func handleAdHocCommandRunTask(c *gin.Context) {
+	var runTask = parseRunTaskPayload(c)
+	if !validateRunTaskCallback(c, runTask) {
+		return
+	}
+	if !reserveRunTask(c, runTask) {
+		return
+	}
+	inventoryId := c.Param("inventoryId")
+
+	log.Printf("Ad Hoc Command Run Task event received for Inventory %s", inventoryId)
 
-		var workflowJobTemplateResponse, wfjtErr = ansibleWorkflowJobTemplateRequest(runTask, workflowTemplateId, ansibleAuthResponse)
-		if wfjtErr != nil {
-			errResponse := createRunTaskResponse(Failed, wfjtErr.Error(), "")
+	acknowledgeRunTask(c, runTask, AdHocEndpoint, func(action ActionConfig) {
+		processAdHocCommandRunTask(runTask, inventoryId, action)
+	})
+}
+
+// processAdHocCommandRunTask does the work of the adhoc endpoint, sending the
+// result to the Run Task callback URL
+func processAdHocCommandRunTask(runTask RunTaskRequest, inventoryId string, action ActionConfig) {
+	defer releaseRunTask()
+
+	// the sandbox inventory is configured, so unlike the one in the URL it
+	// may be a template
+	if speculativePolicy(runTask, action) == SpeculativeSandbox {
+		sandbox, renderErr := resolveActionValue("speculative inventory", action.Speculative.Inventory, newTemplateData(runTask))
+		if renderErr != nil {
+			errResponse := actionRunTaskResponse(action, runTask, Failed, renderErr.Error(), "")
 			tfcRunTaskResponse(errResponse, runTask.TaskResultCallbackURL, runTask.AccessToken)
-		} else {
-			response := createRunTaskResponse(Passed, fmt.Sprintf("Succesfully triggered Ansible Workflow Job Template, %s", workflowJobTemplateResponse.Name), fmt.Sprintf("%s/#/jobs/workflow/%d/output", ansibleHost, workflowJobTemplateResponse.ID))
-			tfcRunTaskResponse(response, runTask.TaskResultCallbackURL, runTask.AccessToken)
+			return
 		}
-		ansibleTokenRevoke(ansibleAuthResponse)
+		inventoryId = sandbox
+	}
+	if invErr := checkAdHocInventory(inventoryId); invErr != nil {
+		errResponse := actionRunTaskResponse(action, runTask, Failed, invErr.Error(), "")
+		tfcRunTaskResponse(errResponse, runTask.TaskResultCallbackURL, runTask.AccessToken)
+		return
+	}
+
+	prepared, response := prepareRunTask(runTask, action, AdHocEndpoint, inventoryId)
+	if response != nil {
+		tfcRunTaskResponse(response, runTask.TaskResultCallbackURL, runTask.AccessToken)
+		return
+	}
+	defer prepared.finish()
+	action = prepared.action
+
+	var adhocResponse, adhocErr = ansibleAdHocCommandRequest(runTask, inventoryId, action, prepared.auth)
+	if adhocErr != nil {
+		errResponse := actionRunTaskResponse(action, runTask, Failed, adhocErr.Error(), "")
+		tfcRunTaskResponse(errResponse, runTask.TaskResultCallbackURL, runTask.AccessToken)
+		return
 	}
 
+	message := fmt.Sprintf("Succesfully triggered Ansible Ad Hoc Command, %s%s", adhocResponse.Name, speculativeMessage(runTask, action))
+	detailsURL := fmt.Sprintf("%s/#/jobs/command/%d/output", ansibleHost, adhocResponse.ID)
+	response = actionRunTaskResponse(action, runTask, Passed, message, detailsURL)
+	if action.Wait {
+		response = waitedRunTaskResponse(action, runTask, message, fmt.Sprintf("/api/v2/ad_hoc_commands/%d/", adhocResponse.ID), detailsURL, prepared.auth)
+	}
+	tfcRunTaskResponse(response, runTask.TaskResultCallbackURL, runTask.AccessToken)
 }
 
 func handleInventoryRunTask(c *gin.Context) {
 	var runTask = parseRunTaskPayload(c)
+	if !validateRunTaskCallback(c, runTask) {
+		return
+	}
+	if !reserveRunTask(c, runTask) {
+		return
+	}
 	orgIdStr := c.Param("organisationId")
 	organisationId, err := strconv.Atoi(orgIdStr)
 	if err != nil {
@@ -843,32 +1048,153 @@ func handleInventoryRunTask(c *gin.Context) {
 
 	log.Printf("Inventory Run Task event received for Organisation ID %s", orgIdStr)
 
-	c.Status(http.StatusOK)
-	// if this isn't a test, send the ackowledgement that we've had the request
-	if runTask.AccessToken != TestToken {
-		var ansibleAuthResponse, tokErr = ansibleTokenRequest()
-		if tokErr != nil {
-			errResponse := createRunTaskResponse(Failed, tokErr.Error(), "")
+	acknowledgeRunTask(c, runTask, InventoryEndpoint, func(action ActionConfig) {
+		processInventoryRunTask(runTask, organisationId, action)
+	})
+}
+
+// processInventoryRunTask does the work of the inventory endpoint, sending
+// the result to the Run Task callback URL
+func processInventoryRunTask(runTask RunTaskRequest, organisationId int, action ActionConfig) {
+	defer releaseRunTask()
+
+	prepared, response := prepareRunTask(runTask, action, InventoryEndpoint, strconv.Itoa(organisationId))
+	if response != nil {
+		tfcRunTaskResponse(response, runTask.TaskResultCallbackURL, runTask.AccessToken)
+		return
+	}
+	defer prepared.finish()
+	action = prepared.action
+	ansibleAuthResponse := prepared.auth
+
+	if action.Destroy.enabled() {
+		isDestroy, destroyErr := tfcIsDestroyRun(runTask)
+		if destroyErr != nil {
+			errResponse := actionRunTaskResponse(action, runTask, Failed, destroyErr.Error(), "")
 			tfcRunTaskResponse(errResponse, runTask.TaskResultCallbackURL, runTask.AccessToken)
+			return
+		}
+		if isDestroy {
+			response := destroyRunTaskResponse(runTask, organisationId, action, ansibleAuthResponse)
+			tfcRunTaskResponse(response, runTask.TaskResultCallbackURL, runTask.AccessToken)
+			return
 		}
+	}
 
-		var ansibleInvResponse, invErr = ansibleCreateInventoryRequest(runTask, organisationId, ansibleAuthResponse)
-		if invErr != nil {
-			errResponse := createRunTaskResponse(Failed, invErr.Error(), "")
+	if runTask.Stage == PostApply {
+		var ansibleInvResponse, summary, syncErr = ansibleSyncInventoryRequest(runTask, organisationId, action, ansibleAuthResponse)
+		if syncErr != nil {
+			errResponse := actionRunTaskResponse(action, runTask, Failed, syncErr.Error(), "")
 			tfcRunTaskResponse(errResponse, runTask.TaskResultCallbackURL, runTask.AccessToken)
-		} else {
-			response := createRunTaskResponse(Passed, fmt.Sprintf("Successfully created Ansible Inventory %s", ansibleInvResponse.Name), fmt.Sprintf("%s/#/inventories/inventory/%d/details", ansibleHost, ansibleInvResponse.ID))
-			tfcRunTaskResponse(response, runTask.TaskResultCallbackURL, runTask.AccessToken)
+			return
 		}
-		ansibleTokenRevoke(ansibleAuthResponse)
+		response := actionRunTaskResponse(action, runTask, Passed, fmt.Sprintf("Successfully synchronised Ansible Inventory %s, %s", ansibleInvResponse.Name, summary), inventoryDetailsURL(ansibleInvResponse))
+		tfcRunTaskResponse(response, runTask.TaskResultCallbackURL, runTask.AccessToken)
+		return
 	}
 
+	var ansibleInvResponse, invErr = ansibleCreateInventoryRequest(runTask, organisationId, action, ansibleAuthResponse)
+	if invErr == nil && len(action.Inventory.Sources) > 0 {
+		running := createRunTaskResponse(Running, fmt.Sprintf("Created Ansible Inventory %s, waiting for %d inventory sources to sync", ansibleInvResponse.Name, len(action.Inventory.Sources)), inventoryDetailsURL(ansibleInvResponse))
+		tfcRunTaskResponse(running, runTask.TaskResultCallbackURL, runTask.AccessToken)
+		invErr = ansibleSyncInventorySourcesRequest(runTask, ansibleInvResponse, organisationId, action, ansibleAuthResponse)
+	}
+	if invErr != nil {
+		errResponse := actionRunTaskResponse(action, runTask, Failed, invErr.Error(), "")
+		tfcRunTaskResponse(errResponse, runTask.TaskResultCallbackURL, runTask.AccessToken)
+		return
+	}
+	response = actionRunTaskResponse(action, runTask, Passed, fmt.Sprintf("Successfully created Ansible Inventory %s%s%s", ansibleInvResponse.Name, inventoryKindMessage(ansibleInvResponse), inventorySourcesMessage(action)), inventoryDetailsURL(ansibleInvResponse))
+	tfcRunTaskResponse(response, runTask.TaskResultCallbackURL, runTask.AccessToken)
 }
 
 func init() {
 	ansibleHost = os.Getenv("ARTS_ANSIBLE_HOST")
 	ansibleUser = os.Getenv("ARTS_ANSIBLE_USER")
 	ansiblePassword = os.Getenv("ARTS_ANSIBLE_PASSWORD")
+
+	tlsCertFile = os.Getenv("ARTS_TLS_CERT_FILE")
+	tlsKeyFile = os.Getenv("ARTS_TLS_KEY_FILE")
+	tlsClientCAFile = os.Getenv("ARTS_TLS_CLIENT_CA_FILE")
+	tlsMinVersion = os.Getenv("ARTS_TLS_MIN_VERSION")
+	tlsCipherSuites = os.Getenv("ARTS_TLS_CIPHER_SUITES")
+
+	allowedHosts, ok := os.LookupEnv("ARTS_TFC_ALLOWED_HOSTS")
+	if !ok {
+		allowedHosts = DefaultTFCAllowedHosts
+	}
+	tfcAllowedHosts = parseAllowedHosts(allowedHosts)
+
+	actionsFile = os.Getenv("ARTS_ACTIONS_FILE")
+	var actionsErr error
+	actions, actionsErr = loadActions(actionsFile)
+	if actionsErr != nil {
+		log.Fatal(actionsErr)
+	}
+	tfcAllowPrivateNetworks, _ = strconv.ParseBool(os.Getenv("ARTS_TFC_ALLOW_PRIVATE_NETWORKS"))
+	tfcToken = os.Getenv("ARTS_TFC_TOKEN")
+
+	var waitErr error
+	waitInterval, waitErr = parseWaitDuration("ARTS_WAIT_INTERVAL", os.Getenv("ARTS_WAIT_INTERVAL"), DefaultWaitInterval)
+	if waitErr != nil {
+		log.Fatal(waitErr)
+	}
+	waitTimeout, waitErr = parseWaitDuration("ARTS_WAIT_TIMEOUT", os.Getenv("ARTS_WAIT_TIMEOUT"), DefaultWaitTimeout)
+	if waitErr != nil {
+		log.Fatal(waitErr)
+	}
+
+	var limitErr error
+	ansibleRateLimit, limitErr = parseRateLimit("ARTS_ANSIBLE_RATE_LIMIT", os.Getenv("ARTS_ANSIBLE_RATE_LIMIT"), DefaultAnsibleRateLimit)
+	if limitErr != nil {
+		log.Fatal(limitErr)
+	}
+	ansibleRateBurst, limitErr = parseCount("ARTS_ANSIBLE_RATE_BURST", os.Getenv("ARTS_ANSIBLE_RATE_BURST"), DefaultAnsibleRateBurst)
+	if limitErr != nil {
+		log.Fatal(limitErr)
+	}
+	ansibleLaunchRateLimit, limitErr = parseRateLimit("ARTS_ANSIBLE_LAUNCH_RATE_LIMIT", os.Getenv("ARTS_ANSIBLE_LAUNCH_RATE_LIMIT"), DefaultAnsibleLaunchRateLimit)
+	if limitErr != nil {
+		log.Fatal(limitErr)
+	}
+	ansibleLaunchRateBurst, limitErr = parseCount("ARTS_ANSIBLE_LAUNCH_RATE_BURST", os.Getenv("ARTS_ANSIBLE_LAUNCH_RATE_BURST"), DefaultAnsibleLaunchRateBurst)
+	if limitErr != nil {
+		log.Fatal(limitErr)
+	}
+	ansibleMaxRetries, limitErr = parseCount("ARTS_ANSIBLE_MAX_RETRIES", os.Getenv("ARTS_ANSIBLE_MAX_RETRIES"), DefaultAnsibleMaxRetries)
+	if limitErr != nil {
+		log.Fatal(limitErr)
+	}
+	breakerFailures, limitErr = parseCount("ARTS_ANSIBLE_BREAKER_FAILURES", os.Getenv("ARTS_ANSIBLE_BREAKER_FAILURES"), DefaultBreakerFailures)
+	if limitErr != nil {
+		log.Fatal(limitErr)
+	}
+	breakerCooldown, limitErr = parseWaitDuration("ARTS_ANSIBLE_BREAKER_COOLDOWN", os.Getenv("ARTS_ANSIBLE_BREAKER_COOLDOWN"), DefaultBreakerCooldown)
+	if limitErr != nil {
+		log.Fatal(limitErr)
+	}
+	breakerPassAdvisory, _ = strconv.ParseBool(os.Getenv("ARTS_ANSIBLE_BREAKER_PASS_ADVISORY"))
+	queueSize, queueErr := parseCount("ARTS_QUEUE_SIZE", os.Getenv("ARTS_QUEUE_SIZE"), DefaultQueueSize)
+	if queueErr != nil {
+		log.Fatal(queueErr)
+	}
+	runTaskQueue = newRunTaskQueue(queueSize)
+
+	var clientErr error
+	ansibleClient, clientErr = newOutboundClient(AnsibleTarget)
+	if clientErr != nil {
+		log.Fatal(clientErr)
+	}
+	// the controller transport times out each attempt itself, so that rate
+	// limiting and retries are not counted against a single request
+	ansibleTransport = newControllerTransport(ansibleClient.Transport, ansibleClient.Timeout)
+	ansibleTransport.controllerFor(controllerHost(ansibleHost))
+	ansibleClient.Transport = ansibleTransport
+	ansibleClient.Timeout = 0
+	tfcClient, clientErr = newOutboundClient(TFCTarget)
+	if clientErr != nil {
+		log.Fatal(clientErr)
+	}
 }
 
 func main() {
@@ -882,5 +1208,26 @@ func main() {
 	router.POST("/public/job/:jobTemplateId", handleJobTemplateRunTask)
 	router.POST("/public/workflow/:workflowTemplateId", handleWorkflowJobTemplateRunTask)
 	router.POST("/public/inventory/:organisationId", handleInventoryRunTask)
-	router.Run(fmt.Sprintf("%s:%s", *iface, *port))
+	router.POST("/public/adhoc/:inventoryId", handleAdHocCommandRunTask)
+	router.GET("/readyz", handleReadiness)
+	router.GET("/metrics", handleMetrics)
+
+	address := fmt.Sprintf("%s:%s", *iface, *port)
+	if len(tlsCertFile) == 0 {
+		router.Run(address)
+		return
+	}
+
+	tlsConfig, tlsErr := serverTLSConfig()
+	if tlsErr != nil {
+		log.Fatal(tlsErr)
+	}
+
+	server := &http.Server{
+		Addr:      address,
+		Handler:   router,
+		TLSConfig: tlsConfig,
+	}
+	log.Printf("Listening and serving HTTPS on %s", address)
+	log.Fatal(server.ListenAndServeTLS("", ""))
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestTFC serves TFC/TFE API responses from handler, allowing ARTS to
+// call it, and returns a Run Task request whose URLs point at it
+func newTestTFC(t *testing.T, handler http.HandlerFunc) RunTaskRequest {
+	t.Helper()
+
+	server := httptest.NewTLSServer(handler)
+	t.Cleanup(server.Close)
+
+	client, allowedHosts, allowPrivate := tfcClient, tfcAllowedHosts, tfcAllowPrivateNetworks
+	t.Cleanup(func() {
+		tfcClient, tfcAllowedHosts, tfcAllowPrivateNetworks = client, allowedHosts, allowPrivate
+	})
+	tfcClient = server.Client()
+	tfcAllowedHosts = []string{"127.0.0.1"}
+	tfcAllowPrivateNetworks = true
+
+	return RunTaskRequest{
+		AccessToken:           "token",
+		Stage:                 PreApply,
+		RunID:                 "run-1",
+		WorkspaceID:           "ws-1",
+		WorkspaceName:         "web-prod",
+		TaskResultCallbackURL: server.URL + "/api/v2/task-results/taskrs-1/callback",
+		PlanJSONAPIURL:        server.URL + "/api/v2/plans/plan-1/json-output",
+	}
+}
+
+// newTestController serves controller API responses from handler, and
+// returns a token to call it with
+func newTestController(t *testing.T, handler http.HandlerFunc) *AnsibleAuthResponse {
+	t.Helper()
+
+	server := httptest.NewTLSServer(handler)
+	t.Cleanup(server.Close)
+
+	host, client := ansibleHost, ansibleClient
+	t.Cleanup(func() {
+		ansibleHost, ansibleClient = host, client
+	})
+	ansibleHost = server.URL
+	ansibleClient = server.Client()
+
+	return &AnsibleAuthResponse{ID: 1, Token: "token"}
+}
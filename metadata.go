@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	WorkspaceTagsSource  = "workspace"
+	PlanTagsSource       = "plan"
+	DefaultTagsAttribute = "tags"
+)
+
+var nonGroupNameCharacters = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// InventoryVariablesConfig sets the variables written to the workspace
+// inventory: TFC/TFE metadata, selected Terraform variables, and templated
+// extra variables
+type InventoryVariablesConfig struct {
+	Metadata           bool           `yaml:"metadata,omitempty"`
+	TerraformVariables []string       `yaml:"terraform_variables,omitempty"`
+	Extra              map[string]any `yaml:"extra,omitempty"`
+}
+
+// GroupTreeConfig builds nested groups from tags, one level per tag key e.g.
+// environment > region > role. Tags come from the workspace, or from the
+// resources in the run's plan. Hosts are added to the deepest group their tags
+// reach: every host of the inventory for workspace tags, or for plan tags the
+// host each resource's Host template renders, with the resource as
+// {{ .Resource }}
+type GroupTreeConfig struct {
+	Source    string   `yaml:"source,omitempty"`
+	Levels    []string `yaml:"levels,omitempty"`
+	Attribute string   `yaml:"attribute,omitempty"`
+	Host      string   `yaml:"host,omitempty"`
+}
+
+// taggedHosts is a set of tags and the hosts they apply to
+type taggedHosts struct {
+	tags  map[string]string
+	hosts []string
+}
+
+type TFCWorkspaceResponse struct {
+	Data struct {
+		ID         string `json:"id"`
+		Attributes struct {
+			Name     string   `json:"name"`
+			TagNames []string `json:"tag-names"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+func (v InventoryVariablesConfig) enabled() bool {
+	return v.Metadata || len(v.TerraformVariables) > 0 || len(v.Extra) > 0
+}
+
+func (g GroupTreeConfig) enabled() bool {
+	return len(g.Levels) > 0
+}
+
+func checkGroupTreeConfig(tree GroupTreeConfig) error {
+	switch tree.Source {
+	case "", WorkspaceTagsSource, PlanTagsSource:
+		return nil
+	default:
+		return fmt.Errorf("group_tree source must be %s or %s, not %q", WorkspaceTagsSource, PlanTagsSource, tree.Source)
+	}
+}
+
+// inventoryVariables returns the variables for the workspace inventory
+func inventoryVariables(config InventoryVariablesConfig, data *TemplateData) (map[string]any, error) {
+	variables := make(map[string]any)
+
+	if config.Metadata {
+		variables["tfc_organization"] = data.OrganizationName
+		variables["tfc_workspace"] = data.WorkspaceName
+		variables["tfc_workspace_id"] = data.WorkspaceID
+		variables["tfc_workspace_url"] = data.WorkspaceAppURL
+		variables["tfc_run_id"] = data.RunID
+		variables["tfc_run_url"] = data.RunAppURL
+		if len(data.VcsRepoURL) > 0 {
+			variables["tfc_vcs_repo_url"] = data.VcsRepoURL
+			variables["tfc_vcs_branch"] = data.VcsBranch
+			variables["tfc_vcs_commit_url"] = data.VcsCommitURL
+		}
+	}
+
+	if len(config.TerraformVariables) > 0 {
+		terraformVariables, varsErr := data.Variables()
+		if varsErr != nil {
+			return nil, varsErr
+		}
+		for _, name := range config.TerraformVariables {
+			value, ok := terraformVariables[name]
+			if !ok {
+				return nil, fmt.Errorf("the Workspace has no non-sensitive Terraform variable %s", name)
+			}
+			variables[name] = value
+		}
+	}
+
+	if len(config.Extra) > 0 {
+		extra, renderErr := renderValues("inventory variables", config.Extra, data)
+		if renderErr != nil {
+			return nil, renderErr
+		}
+		for key, value := range extra.(map[string]any) {
+			variables[key] = value
+		}
+	}
+
+	return variables, nil
+}
+
+// encodeInventoryVariables returns inventory variables as YAML
+func encodeInventoryVariables(variables map[string]any) (string, error) {
+	if len(variables) == 0 {
+		return "", nil
+	}
+	encoded, err := yaml.Marshal(variables)
+	return string(encoded), err
+}
+
+// parseTags reads key:value or key=value tags into a map. Tags without a
+// value are ignored
+func parseTags(tagNames []string) map[string]string {
+	tags := make(map[string]string)
+	for _, tagName := range tagNames {
+		key, value, ok := strings.Cut(tagName, ":")
+		if !ok {
+			key, value, ok = strings.Cut(tagName, "=")
+		}
+		if ok && len(key) > 0 && len(value) > 0 {
+			tags[key] = value
+		}
+	}
+	return tags
+}
+
+func tfcWorkspaceTags(request RunTaskRequest) (map[string]string, error) {
+	var workspace TFCWorkspaceResponse
+	if err := tfcAPIRequest(request, fmt.Sprintf("/api/v2/workspaces/%s", url.PathEscape(request.WorkspaceID)), &workspace); err != nil {
+		return nil, fmt.Errorf("unable to read Workspace tags: %s", err)
+	}
+	return parseTags(workspace.Data.Attributes.TagNames), nil
+}
+
+// planResourceTags returns the tags of each resource the plan leaves in place,
+// with the resource's host if the tree has a Host template
+func planResourceTags(request RunTaskRequest, tree GroupTreeConfig) ([]taggedHosts, error) {
+	plan, planErr := tfcPlanJSON(request)
+	if planErr != nil {
+		return nil, planErr
+	}
+
+	attribute := tree.Attribute
+	if len(attribute) == 0 {
+		attribute = DefaultTagsAttribute
+	}
+
+	data := newTemplateData(request)
+	var resourceTags []taggedHosts
+	for i := range plan.ResourceChanges {
+		change := &plan.ResourceChanges[i]
+		after, ok := change.Change.After.(map[string]any)
+		if change.Mode != "managed" || !ok {
+			continue
+		}
+		tagValues, ok := after[attribute].(map[string]any)
+		if !ok {
+			continue
+		}
+
+		tagged := taggedHosts{tags: make(map[string]string)}
+		for key, value := range tagValues {
+			tagged.tags[key] = fmt.Sprint(value)
+		}
+
+		if len(tree.Host) > 0 {
+			data.Resource = change
+			host, renderErr := renderResourceTemplate(fmt.Sprintf("group tree host of %s", change.Address), tree.Host, data)
+			if renderErr != nil {
+				return nil, renderErr
+			}
+			if host = strings.TrimSpace(host); len(host) > 0 {
+				tagged.hosts = []string{host}
+			}
+		}
+		resourceTags = append(resourceTags, tagged)
+	}
+	return resourceTags, nil
+}
+
+func groupName(level string, value string) string {
+	return strings.Trim(nonGroupNameCharacters.ReplaceAllString(strings.ToLower(level+"_"+value), "_"), "_")
+}
+
+// groupTree returns the groups of the tree, each with its child groups and
+// the hosts whose tags end there. A branch stops at the first level a set of
+// tags has no value for. Only hosts in the inventory are added
+func groupTree(request RunTaskRequest, tree GroupTreeConfig, inventoryHosts map[string]map[string]any) (map[string]*desiredGroup, error) {
+	var tagSets []taggedHosts
+	if tree.Source == PlanTagsSource {
+		resourceTags, tagsErr := planResourceTags(request, tree)
+		if tagsErr != nil {
+			return nil, tagsErr
+		}
+		tagSets = resourceTags
+	} else {
+		workspaceTags, tagsErr := tfcWorkspaceTags(request)
+		if tagsErr != nil {
+			return nil, tagsErr
+		}
+		workspaceHosts := make([]string, 0, len(inventoryHosts))
+		for host := range inventoryHosts {
+			workspaceHosts = append(workspaceHosts, host)
+		}
+		tagSets = []taggedHosts{{tags: workspaceTags, hosts: workspaceHosts}}
+	}
+
+	groups := make(map[string]*desiredGroup)
+	for _, tagged := range tagSets {
+		parent := ""
+		for _, level := range tree.Levels {
+			value, ok := tagged.tags[level]
+			if !ok || len(value) == 0 {
+				break
+			}
+
+			name := groupName(level, value)
+			if _, ok := groups[name]; !ok {
+				groups[name] = &desiredGroup{Vars: map[string]any{}}
+			}
+			if len(parent) > 0 {
+				groups[parent].Children = mergeNames(groups[parent].Children, []string{name})
+			}
+			parent = name
+		}
+
+		if len(parent) == 0 {
+			continue
+		}
+		for _, host := range tagged.hosts {
+			if _, ok := inventoryHosts[host]; ok {
+				groups[parent].Hosts = mergeNames(groups[parent].Hosts, []string{host})
+			}
+		}
+	}
+
+	return groups, nil
+}
+
+// mergeGroupTree adds the groups of a tree to the desired groups, keeping the
+// vars of groups that are already desired
+func mergeGroupTree(desired map[string]*desiredGroup, tree map[string]*desiredGroup) {
+	for name, group := range tree {
+		existing, ok := desired[name]
+		if !ok {
+			desired[name] = group
+			continue
+		}
+		existing.Hosts = mergeNames(existing.Hosts, group.Hosts)
+		existing.Children = mergeNames(existing.Children, group.Children)
+	}
+}
+
+// mergeNames returns the sorted, de-duplicated names of both lists
+func mergeNames(names []string, more []string) []string {
+	seen := make(map[string]bool)
+	var merged []string
+	for _, name := range append(append([]string{}, names...), more...) {
+		if !seen[name] {
+			seen[name] = true
+			merged = append(merged, name)
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const groupTreeTestPlan = `{"resource_changes": [
+	{"address": "aws_instance.web1", "mode": "managed", "type": "aws_instance", "change": {"actions": ["create"], "after": {"name": "web-1", "tags": {"Env": "prod", "Role": "web"}}}},
+	{"address": "aws_instance.web2", "mode": "managed", "type": "aws_instance", "change": {"actions": ["create"], "after": {"name": "web-2", "tags": {"Env": "prod", "Role": "web"}}}},
+	{"address": "aws_instance.unnamed", "mode": "managed", "type": "aws_instance", "change": {"actions": ["create"], "after": {"tags": {"Env": "prod"}}}}
+]}`
+
+func serveGroupTreePlan(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(groupTreeTestPlan))
+}
+
+func TestGroupTreeAddsHostsToLeafGroups(t *testing.T) {
+	request := newTestTFC(t, serveGroupTreePlan)
+	tree := GroupTreeConfig{Source: PlanTagsSource, Levels: []string{"Env", "Role"}, Host: "{{ .Resource.Change.After.name }}"}
+	hosts := map[string]map[string]any{"web-1": {}, "web-2": {}}
+
+	groups, treeErr := groupTree(request, tree, hosts)
+	if treeErr != nil {
+		t.Fatal(treeErr)
+	}
+	if !reflect.DeepEqual(groups["env_prod"].Children, []string{"role_web"}) {
+		t.Errorf("expected env_prod to have the single child role_web, got %v", groups["env_prod"].Children)
+	}
+	if len(groups["env_prod"].Hosts) > 0 {
+		t.Errorf("expected env_prod to have no hosts, got %v", groups["env_prod"].Hosts)
+	}
+	if !reflect.DeepEqual(groups["role_web"].Hosts, []string{"web-1", "web-2"}) {
+		t.Errorf("expected role_web to have hosts web-1 and web-2, got %v", groups["role_web"].Hosts)
+	}
+}
+
+func TestCreateInventoryFailsOnGroupTreeTemplateErrors(t *testing.T) {
+	request := newTestTFC(t, serveGroupTreePlan)
+	created := false
+	auth := newTestController(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/api/v2/inventories/" {
+			created = true
+		}
+		w.Write([]byte(`{"id": 8, "name": "web-prod"}`))
+	})
+	action := ActionConfig{Inventory: InventoryConfig{GroupTree: GroupTreeConfig{Source: PlanTagsSource, Levels: []string{"Env"}, Host: "{{ .Resource.Chnage.After.name }}"}}}
+
+	_, createErr := ansibleCreateInventoryRequest(request, 1, action, auth)
+	if createErr == nil || !strings.Contains(createErr.Error(), "group tree host") {
+		t.Fatalf("expected a group tree host template error, got %v", createErr)
+	}
+	if created {
+		t.Error("expected no inventory to be created")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+const (
+	AnsibleTarget = "ANSIBLE"
+	TFCTarget     = "TFC"
+)
+
+var ansibleClient *http.Client
+var tfcClient *http.Client
+
+// newOutboundClient builds the HTTP client used for calls to a target (the
+// AAP controller or TFC/TFE) from its ARTS_<target>_* environment variables
+func newOutboundClient(target string) (*http.Client, error) {
+	tlsConfig, tlsErr := outboundTLSConfig(target)
+	if tlsErr != nil {
+		return nil, tlsErr
+	}
+
+	proxy, proxyAddr, proxyErr := outboundProxy(target)
+	if proxyErr != nil {
+		return nil, proxyErr
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.Proxy = proxy
+	if target == TFCTarget {
+		transport.DialContext = tfcDialContext(&net.Dialer{Timeout: time.Second * 30, KeepAlive: time.Second * 30}, proxyAddr)
+	}
+
+	return &http.Client{
+		Timeout:   time.Second * 10,
+		Transport: transport,
+	}, nil
+}
+
+func outboundTLSConfig(target string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if caFile := os.Getenv(fmt.Sprintf("ARTS_%s_CA_FILE", target)); len(caFile) > 0 {
+		pool, poolErr := x509.SystemCertPool()
+		if poolErr != nil {
+			pool = x509.NewCertPool()
+		}
+
+		pem, readErr := os.ReadFile(caFile)
+		if readErr != nil {
+			return nil, readErr
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	certFile := os.Getenv(fmt.Sprintf("ARTS_%s_CLIENT_CERT_FILE", target))
+	keyFile := os.Getenv(fmt.Sprintf("ARTS_%s_CLIENT_KEY_FILE", target))
+	if len(certFile) > 0 || len(keyFile) > 0 {
+		cert, certErr := tls.LoadX509KeyPair(certFile, keyFile)
+		if certErr != nil {
+			return nil, fmt.Errorf("unable to load %s client certificate: %s", target, certErr)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if insecure := os.Getenv(fmt.Sprintf("ARTS_%s_INSECURE_SKIP_VERIFY", target)); len(insecure) > 0 {
+		skip, parseErr := strconv.ParseBool(insecure)
+		if parseErr != nil {
+			return nil, fmt.Errorf("invalid ARTS_%s_INSECURE_SKIP_VERIFY: %s", target, parseErr)
+		}
+		if skip {
+			log.Printf("WARNING: TLS certificate verification is disabled for %s. Do not use this outside of a lab", target)
+			config.InsecureSkipVerify = true
+		}
+	}
+
+	return config, nil
+}
+
+// outboundProxy honours the standard HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// variables, which ARTS_<target>_PROXY and ARTS_<target>_NO_PROXY override.
+// A proxy of "none" sends the target's traffic directly. The host:port of the
+// HTTPS proxy, if any, is returned too
+func outboundProxy(target string) (func(*http.Request) (*url.URL, error), string, error) {
+	config := httpproxy.FromEnvironment()
+
+	if proxy, ok := os.LookupEnv(fmt.Sprintf("ARTS_%s_PROXY", target)); ok {
+		if proxy == "none" {
+			proxy = ""
+		} else if _, parseErr := url.Parse(proxy); parseErr != nil {
+			return nil, "", fmt.Errorf("invalid ARTS_%s_PROXY: %s", target, parseErr)
+		}
+		config.HTTPProxy = proxy
+		config.HTTPSProxy = proxy
+	}
+
+	if noProxy, ok := os.LookupEnv(fmt.Sprintf("ARTS_%s_NO_PROXY", target)); ok {
+		config.NoProxy = noProxy
+	}
+
+	proxyFunc := config.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}, proxyAddress(config.HTTPSProxy), nil
+}
+
+// proxyAddress returns the host:port a proxy URL connects to, with the
+// default port for its scheme
+func proxyAddress(proxy string) string {
+	if len(proxy) == 0 {
+		return ""
+	}
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	parsed, parseErr := url.Parse(proxy)
+	if parseErr != nil || len(parsed.Hostname()) == 0 {
+		return ""
+	}
+
+	port := parsed.Port()
+	if len(port) == 0 {
+		switch parsed.Scheme {
+		case "https":
+			port = "443"
+		case "socks5", "socks5h":
+			port = "1080"
+		default:
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(parsed.Hostname(), port)
+}
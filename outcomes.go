@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	TaskResultOutcomes = "task-result-outcomes"
+)
+
+// outcome tag levels understood by TFC/TFE
+const (
+	OutcomeLevelNone    = "none"
+	OutcomeLevelInfo    = "info"
+	OutcomeLevelWarning = "warning"
+	OutcomeLevelError   = "error"
+)
+
+// MaxOutcomeDescription is the longest outcome description TFC/TFE accepts
+const MaxOutcomeDescription = 100
+
+const MaxArtifactLength = 200
+
+type RunTaskRelationships struct {
+	Outcomes struct {
+		Data []RunTaskOutcome `json:"data"`
+	} `json:"outcomes"`
+}
+
+// RunTaskOutcome is a structured result shown alongside a Run Task's message
+type RunTaskOutcome struct {
+	Type       string `json:"type"`
+	Attributes struct {
+		OutcomeID   string                         `json:"outcome-id"`
+		Description string                         `json:"description"`
+		Body        string                         `json:"body,omitempty"`
+		URL         string                         `json:"url,omitempty"`
+		Tags        map[string][]RunTaskOutcomeTag `json:"tags,omitempty"`
+	} `json:"attributes"`
+}
+
+type RunTaskOutcomeTag struct {
+	Label string `json:"label"`
+	Level string `json:"level,omitempty"`
+}
+
+func newRunTaskOutcome(id string, description string, url string) RunTaskOutcome {
+	var outcome RunTaskOutcome
+	outcome.Type = TaskResultOutcomes
+	outcome.Attributes.OutcomeID = id
+	outcome.Attributes.Description = truncate(description, MaxOutcomeDescription)
+	outcome.Attributes.URL = url
+	return outcome
+}
+
+// tag adds a labelled tag to an outcome
+func (o *RunTaskOutcome) tag(name string, label string, level string) {
+	if o.Attributes.Tags == nil {
+		o.Attributes.Tags = make(map[string][]RunTaskOutcomeTag)
+	}
+	o.Attributes.Tags[name] = append(o.Attributes.Tags[name], RunTaskOutcomeTag{Label: label, Level: level})
+}
+
+// withOutcomes adds outcomes to a Run Task response
+func withOutcomes(response *RunTaskResponse, outcomes []RunTaskOutcome) *RunTaskResponse {
+	if len(outcomes) == 0 {
+		return response
+	}
+	if response.Data.Relationships == nil {
+		response.Data.Relationships = &RunTaskRelationships{}
+	}
+	response.Data.Relationships.Outcomes.Data = append(response.Data.Relationships.Outcomes.Data, outcomes...)
+	return response
+}
+
+// jobStatusLevel returns the outcome tag level for a job status
+func jobStatusLevel(status string) string {
+	switch status {
+	case "successful":
+		return OutcomeLevelInfo
+	case "failed", "error":
+		return OutcomeLevelError
+	case "canceled":
+		return OutcomeLevelWarning
+	default:
+		return OutcomeLevelNone
+	}
+}
+
+func truncate(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+	runes := []rune(s)
+	if len(runes) <= length {
+		return s
+	}
+	return string(runes[:length-1]) + "…"
+}
+
+// artifactOutcomes returns the allowed set_stats artifacts of a finished job
+// as an outcome, with a tag for each artifact and a table of them in its body
+func artifactOutcomes(job *AnsibleUnifiedJob, allowed []string, url string) []RunTaskOutcome {
+	var keys []string
+	for _, key := range allowed {
+		if _, ok := job.Artifacts[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+
+	outcome := newRunTaskOutcome(fmt.Sprintf("artifacts-%d", job.ID), fmt.Sprintf("%d artifacts from %s", len(keys), job.Name), url)
+
+	var body strings.Builder
+	body.WriteString("| Artifact | Value |\n| --- | --- |\n")
+	for _, key := range keys {
+		value := artifactValue(job.Artifacts[key])
+		outcome.tag(key, truncate(value, MaxArtifactLength), OutcomeLevelNone)
+		body.WriteString(fmt.Sprintf("| %s | `%s` |\n", key, strings.ReplaceAll(value, "|", "\\|")))
+	}
+	outcome.Attributes.Body = body.String()
+
+	return []RunTaskOutcome{outcome}
+}
+
+// artifactValue formats an artifact, showing non-string values as JSON
+func artifactValue(value any) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// AnsibleLaunchRequirements is what GET on a Job or Workflow Job Template
+// launch endpoint reports is needed, or allowed, when launching it
+type AnsibleLaunchRequirements struct {
+	CanStartWithoutUserInput        bool     `json:"can_start_without_user_input"`
+	PasswordsNeededToStart          []string `json:"passwords_needed_to_start,omitempty"`
+	VariablesNeededToStart          []string `json:"variables_needed_to_start,omitempty"`
+	SurveyEnabled                   bool     `json:"survey_enabled"`
+	CredentialNeededToStart         bool     `json:"credential_needed_to_start"`
+	InventoryNeededToStart          bool     `json:"inventory_needed_to_start"`
+	AskInventoryOnLaunch            bool     `json:"ask_inventory_on_launch"`
+	AskLimitOnLaunch                bool     `json:"ask_limit_on_launch"`
+	AskScmBranchOnLaunch            bool     `json:"ask_scm_branch_on_launch"`
+	AskVariablesOnLaunch            bool     `json:"ask_variables_on_launch"`
+	AskTagsOnLaunch                 bool     `json:"ask_tags_on_launch"`
+	AskSkipTagsOnLaunch             bool     `json:"ask_skip_tags_on_launch"`
+	AskVerbosityOnLaunch            bool     `json:"ask_verbosity_on_launch"`
+	AskDiffModeOnLaunch             bool     `json:"ask_diff_mode_on_launch"`
+	AskJobTypeOnLaunch              bool     `json:"ask_job_type_on_launch"`
+	AskCredentialOnLaunch           bool     `json:"ask_credential_on_launch"`
+	AskExecutionEnvironmentOnLaunch bool     `json:"ask_execution_environment_on_launch"`
+	AskInstanceGroupsOnLaunch       bool     `json:"ask_instance_groups_on_launch"`
+	AskLabelsOnLaunch               bool     `json:"ask_labels_on_launch"`
+	NodeTemplatesMissing            []int    `json:"node_templates_missing,omitempty"`
+	NodePromptsRejected             []int    `json:"node_prompts_rejected,omitempty"`
+	JobTemplateData                 struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	} `json:"job_template_data,omitempty"`
+	WorkflowJobTemplateData struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	} `json:"workflow_job_template_data,omitempty"`
+}
+
+func ansibleLaunchRequirementsRequest(path string, ansibleAuth *AnsibleAuthResponse) (*AnsibleLaunchRequirements, error) {
+	var requirements AnsibleLaunchRequirements
+	if err := ansibleAPIRequest(ansibleAuth, http.MethodGet, path, nil, &requirements); err != nil {
+		return nil, fmt.Errorf("unable to check launch requirements: %w", err)
+	}
+	return &requirements, nil
+}
+
+// launchProblems lists what an action sets that the template will not accept
+// on launch, and what the template needs that the action does not supply
+func launchProblems(requirements *AnsibleLaunchRequirements, inventory int, extraVars map[string]any, prompts map[string]bool) []string {
+	var problems []string
+
+	if len(requirements.PasswordsNeededToStart) > 0 {
+		problems = append(problems, fmt.Sprintf("it needs passwords to start (%s), which ARTS cannot supply", strings.Join(requirements.PasswordsNeededToStart, ", ")))
+	}
+
+	var missing []string
+	for _, variable := range requirements.VariablesNeededToStart {
+		if _, ok := extraVars[variable]; !ok {
+			missing = append(missing, variable)
+		}
+	}
+	if len(missing) > 0 {
+		problems = append(problems, fmt.Sprintf("its survey needs answers for %s", strings.Join(missing, ", ")))
+	}
+
+	if requirements.InventoryNeededToStart && inventory == 0 {
+		problems = append(problems, "it has no inventory, so the action must set one")
+	}
+
+	if len(extraVars) > 0 && !requirements.AskVariablesOnLaunch && !requirements.SurveyEnabled {
+		prompts["extra_vars"] = false
+	}
+
+	var refused []string
+	for field, allowed := range prompts {
+		if !allowed {
+			refused = append(refused, field)
+		}
+	}
+	if len(refused) > 0 {
+		sort.Strings(refused)
+		problems = append(problems, fmt.Sprintf("it does not prompt on launch for %s", strings.Join(refused, ", ")))
+	}
+
+	return problems
+}
+
+func checkJobTemplateLaunch(requirements *AnsibleLaunchRequirements, jtReq *AnsibleJobTemplateRequest) error {
+	prompts := map[string]bool{}
+	if jtReq.Inventory != 0 {
+		prompts["inventory"] = requirements.AskInventoryOnLaunch
+	}
+	if len(jtReq.Limit) > 0 {
+		prompts["limit"] = requirements.AskLimitOnLaunch
+	}
+	if len(jtReq.ScmBranch) > 0 {
+		prompts["scm_branch"] = requirements.AskScmBranchOnLaunch
+	}
+	if len(jtReq.JobTags) > 0 {
+		prompts["job_tags"] = requirements.AskTagsOnLaunch
+	}
+	if len(jtReq.SkipTags) > 0 {
+		prompts["skip_tags"] = requirements.AskSkipTagsOnLaunch
+	}
+	if len(jtReq.JobType) > 0 {
+		prompts["job_type"] = requirements.AskJobTypeOnLaunch
+	}
+	if jtReq.Verbosity != nil {
+		prompts["verbosity"] = requirements.AskVerbosityOnLaunch
+	}
+	if jtReq.DiffMode != nil {
+		prompts["diff_mode"] = requirements.AskDiffModeOnLaunch
+	}
+	if len(jtReq.Credentials) > 0 {
+		prompts["credentials"] = requirements.AskCredentialOnLaunch
+	}
+	if jtReq.ExecutionEnvironment != 0 {
+		prompts["execution_environment"] = requirements.AskExecutionEnvironmentOnLaunch
+	}
+	if len(jtReq.InstanceGroups) > 0 {
+		prompts["instance_groups"] = requirements.AskInstanceGroupsOnLaunch
+	}
+	if len(jtReq.Labels) > 0 {
+		prompts["labels"] = requirements.AskLabelsOnLaunch
+	}
+
+	problems := launchProblems(requirements, jtReq.Inventory, jtReq.ExtraVars, prompts)
+	if requirements.CredentialNeededToStart && len(jtReq.Credentials) == 0 {
+		problems = append(problems, "it has no machine credential, so the action must set one")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("Job Template %s cannot be launched: %s", requirements.JobTemplateData.Name, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func checkWorkflowJobTemplateLaunch(requirements *AnsibleLaunchRequirements, wfjtReq *AnsibleWorkflowJobTemplateRequest) error {
+	prompts := map[string]bool{}
+	if wfjtReq.Inventory != 0 {
+		prompts["inventory"] = requirements.AskInventoryOnLaunch
+	}
+	if len(wfjtReq.Limit) > 0 {
+		prompts["limit"] = requirements.AskLimitOnLaunch
+	}
+	if len(wfjtReq.ScmBranch) > 0 {
+		prompts["scm_branch"] = requirements.AskScmBranchOnLaunch
+	}
+	if len(wfjtReq.JobTags) > 0 {
+		prompts["job_tags"] = requirements.AskTagsOnLaunch
+	}
+	if len(wfjtReq.SkipTags) > 0 {
+		prompts["skip_tags"] = requirements.AskSkipTagsOnLaunch
+	}
+	if len(wfjtReq.Labels) > 0 {
+		prompts["labels"] = requirements.AskLabelsOnLaunch
+	}
+
+	problems := launchProblems(requirements, wfjtReq.Inventory, wfjtReq.ExtraVars, prompts)
+	if len(requirements.NodeTemplatesMissing) > 0 {
+		problems = append(problems, fmt.Sprintf("nodes %v have no template", requirements.NodeTemplatesMissing))
+	}
+	if len(requirements.NodePromptsRejected) > 0 {
+		problems = append(problems, fmt.Sprintf("nodes %v have prompts their templates reject", requirements.NodePromptsRejected))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("Workflow Job Template %s cannot be launched: %s", requirements.WorkflowJobTemplateData.Name, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// ignoredFieldsMessage describes the fields AAP dropped from a launch request
+func ignoredFieldsMessage(ignoredFields map[string]any) string {
+	if len(ignoredFields) == 0 {
+		return ""
+	}
+
+	var fields []string
+	for field := range ignoredFields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	return fmt.Sprintf(". AAP ignored these launch fields: %s", strings.Join(fields, ", "))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+)
+
+const (
+	CommitRef = "commit"
+	BranchRef = "branch"
+)
+
+var commitSHA = regexp.MustCompile(`^[0-9a-fA-F]{7,40}$`)
+
+// ProjectSyncConfig syncs the Job Template's project before launch. Where the
+// project allows branch override, the launch's scm_branch is set to the
+// run's commit, or its branch if Ref is "branch"
+type ProjectSyncConfig struct {
+	Enabled bool   `yaml:"enabled,omitempty"`
+	Ref     string `yaml:"ref,omitempty"`
+}
+
+type AnsibleJobTemplate struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Project int    `json:"project"`
+}
+
+type AnsibleProject struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	ScmType       string `json:"scm_type"`
+	AllowOverride bool   `json:"allow_override"`
+}
+
+type AnsibleProjectUpdateResponse struct {
+	ProjectUpdate int `json:"project_update"`
+}
+
+func checkProjectSyncConfig(sync ProjectSyncConfig) error {
+	switch sync.Ref {
+	case "", CommitRef, BranchRef:
+		return nil
+	default:
+		return fmt.Errorf("project_sync ref must be %s or %s, not %q", CommitRef, BranchRef, sync.Ref)
+	}
+}
+
+// commitFromURL returns the commit SHA at the end of a VCS commit URL, such as
+// https://github.com/org/repo/commit/<sha>
+func commitFromURL(commitURL string) string {
+	parsed, parseErr := url.Parse(commitURL)
+	if parseErr != nil {
+		return ""
+	}
+	sha := path.Base(parsed.Path)
+	if !commitSHA.MatchString(sha) {
+		return ""
+	}
+	return sha
+}
+
+// projectSyncRef returns the git ref the run is planning, preferring its
+// commit unless the branch is asked for
+func projectSyncRef(request RunTaskRequest, sync ProjectSyncConfig) string {
+	if sync.Ref != BranchRef {
+		if sha := commitFromURL(request.VcsCommitURL); len(sha) > 0 {
+			return sha
+		}
+	}
+	return request.VcsBranch
+}
+
+// ansibleSyncProjectRequest updates a Job Template's project and waits for the
+// update to finish. It returns the project, and the scm_branch to launch with
+// if the project allows branch override and the Job Template prompts for it
+func ansibleSyncProjectRequest(request RunTaskRequest, jobTemplateId string, action ActionConfig, ansibleAuth *AnsibleAuthResponse) (*AnsibleProject, string, error) {
+	var jobTemplate AnsibleJobTemplate
+	if err := ansibleAPIRequest(ansibleAuth, http.MethodGet, fmt.Sprintf("/api/v2/job_templates/%s/", jobTemplateId), nil, &jobTemplate); err != nil {
+		return nil, "", fmt.Errorf("unable to read Job Template %s: %w", jobTemplateId, err)
+	}
+	if jobTemplate.Project == 0 {
+		return nil, "", fmt.Errorf("Job Template %s has no project to sync", jobTemplate.Name)
+	}
+
+	var project AnsibleProject
+	if err := ansibleAPIRequest(ansibleAuth, http.MethodGet, fmt.Sprintf("/api/v2/projects/%d/", jobTemplate.Project), nil, &project); err != nil {
+		return nil, "", fmt.Errorf("unable to read project %d: %w", jobTemplate.Project, err)
+	}
+
+	// decide on the branch before syncing, so a launch that would be refused
+	// fails without a wasted sync
+	var scmBranch string
+	if project.AllowOverride && len(action.Launch.ScmBranch) == 0 {
+		requirements, reqErr := ansibleLaunchRequirementsRequest(fmt.Sprintf("/api/v2/job_templates/%s/launch/", jobTemplateId), ansibleAuth)
+		if reqErr != nil {
+			return &project, "", reqErr
+		}
+		if !requirements.AskScmBranchOnLaunch {
+			return &project, "", fmt.Errorf("project_sync cannot launch Job Template %s at the run's commit, as it does not prompt for scm_branch on launch", jobTemplate.Name)
+		}
+		scmBranch = projectSyncRef(request, action.ProjectSync)
+	}
+
+	var update AnsibleProjectUpdateResponse
+	if err := ansibleAPIRequest(ansibleAuth, http.MethodPost, fmt.Sprintf("/api/v2/projects/%d/update/", project.ID), nil, &update); err != nil {
+		return &project, "", fmt.Errorf("unable to sync project %s: %w", project.Name, err)
+	}
+
+	job, waitErr := ansibleWaitForJob(fmt.Sprintf("/api/v2/project_updates/%d/", update.ProjectUpdate), nil, ansibleAuth)
+	if waitErr != nil {
+		return &project, "", waitErr
+	}
+	if resultErr := job.result(); resultErr != nil {
+		return &project, "", fmt.Errorf("project sync failed: %w", resultErr)
+	}
+
+	return &project, scmBranch, nil
+}
+
+// projectSyncMessage describes the project synced before launch
+func projectSyncMessage(project *AnsibleProject, scmBranch string) string {
+	if project == nil {
+		return ""
+	}
+	if len(scmBranch) == 0 {
+		return fmt.Sprintf(", synced project %s", project.Name)
+	}
+	return fmt.Sprintf(", synced project %s at %s", project.Name, scmBranch)
+}
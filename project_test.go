@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSyncProjectFailsBeforeSyncWhenBranchIsNotPrompted(t *testing.T) {
+	synced := false
+	auth := newTestController(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/job_templates/5/":
+			w.Write([]byte(`{"id": 5, "name": "deploy", "project": 2}`))
+		case "/api/v2/projects/2/":
+			w.Write([]byte(`{"id": 2, "name": "playbooks", "allow_override": true}`))
+		case "/api/v2/job_templates/5/launch/":
+			w.Write([]byte(`{"ask_scm_branch_on_launch": false}`))
+		case "/api/v2/projects/2/update/":
+			synced = true
+			w.Write([]byte(`{"project_update": 4}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	request := RunTaskRequest{VcsBranch: "main"}
+	_, _, err := ansibleSyncProjectRequest(request, "5", ActionConfig{ProjectSync: ProjectSyncConfig{Enabled: true}}, auth)
+	if err == nil || !strings.Contains(err.Error(), "does not prompt for scm_branch") {
+		t.Fatalf("expected the missing scm_branch prompt to fail the sync, got %v", err)
+	}
+	if synced {
+		t.Error("expected the project not to be synced")
+	}
+}
+
+func TestLiteralScmBranchIsNotRendered(t *testing.T) {
+	var action ActionConfig
+	action.Launch.setLiteral("scm_branch", "feature/{{ .Oops }}")
+
+	jtReq, err := buildJobTemplateRequest(newTemplateData(RunTaskRequest{}), action, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jtReq.ScmBranch != "feature/{{ .Oops }}" {
+		t.Errorf("expected the branch to be sent as it is, got %q", jtReq.ScmBranch)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	DefaultQueueSize = 100
+)
+
+// runTaskQueue holds a slot for each Run Task being processed, including
+// those waiting for the rate limit or a concurrency slot. A nil queue is
+// unbounded
+var runTaskQueue chan struct{}
+
+func newRunTaskQueue(size int) chan struct{} {
+	if size == 0 {
+		return nil
+	}
+	return make(chan struct{}, size)
+}
+
+// reserveRunTask takes a queue slot for a Run Task, responding with 503
+// Service Unavailable if the queue is full. Test Run Tasks are not queued
+func reserveRunTask(c *gin.Context, runTask RunTaskRequest) bool {
+	if runTask.AccessToken == TestToken || runTaskQueue == nil {
+		return true
+	}
+
+	select {
+	case runTaskQueue <- struct{}{}:
+		return true
+	default:
+		log.Printf("Rejected Run Task for run %s, as %d Run Tasks are already queued", runTask.RunID, cap(runTaskQueue))
+		c.Header("Retry-After", "30")
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "ARTS is busy: too many Run Tasks are queued, try again later"})
+		return false
+	}
+}
+
+// releaseRunTask frees the queue slot of a finished Run Task
+func releaseRunTask() {
+	if runTaskQueue == nil {
+		return
+	}
+	<-runTaskQueue
+}
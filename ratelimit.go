@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultAnsibleRateLimit       = 10.0
+	DefaultAnsibleRateBurst       = 20
+	DefaultAnsibleLaunchRateLimit = 2.0
+	DefaultAnsibleLaunchRateBurst = 5
+	DefaultAnsibleMaxRetries      = 3
+
+	RetryBackoff    = time.Second
+	MaxRetryBackoff = time.Second * 30
+)
+
+var ansibleRateLimit = DefaultAnsibleRateLimit
+var ansibleRateBurst = DefaultAnsibleRateBurst
+var ansibleLaunchRateLimit = DefaultAnsibleLaunchRateLimit
+var ansibleLaunchRateBurst = DefaultAnsibleLaunchRateBurst
+var ansibleMaxRetries = DefaultAnsibleMaxRetries
+
+// tokenBucket allows burst requests at once, refilling at rate per second.
+// A rate of zero allows every request
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// reserve takes a token, returning how long to wait before it can be used
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.rate <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) wait(ctx context.Context) error {
+	delay := b.reserve()
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// controller holds the rate limits and circuit breaker for one controller
+type controller struct {
+	requests *tokenBucket
+	launches *tokenBucket
+	breaker  *circuitBreaker
+}
+
+// controllerTransport rate limits requests to each controller, and retries
+// those the controller turns away with 429 or 503. Each attempt has its own
+// timeout, so retries and waiting for the rate limit are not cut short
+type controllerTransport struct {
+	next    http.RoundTripper
+	timeout time.Duration
+
+	mu          sync.Mutex
+	controllers map[string]*controller
+}
+
+var ansibleTransport *controllerTransport
+
+func newControllerTransport(next http.RoundTripper, timeout time.Duration) *controllerTransport {
+	return &controllerTransport{
+		next:        next,
+		timeout:     timeout,
+		controllers: map[string]*controller{},
+	}
+}
+
+func (t *controllerTransport) controllerFor(host string) *controller {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.controllers[host]
+	if !ok {
+		c = &controller{
+			requests: newTokenBucket(ansibleRateLimit, ansibleRateBurst),
+			launches: newTokenBucket(ansibleLaunchRateLimit, ansibleLaunchRateBurst),
+			breaker:  newCircuitBreaker(host),
+		}
+		t.controllers[host] = c
+	}
+	return c
+}
+
+// isLaunch reports whether a request starts a job on the controller
+func isLaunch(req *http.Request) bool {
+	return req.Method == http.MethodPost && (strings.HasSuffix(req.URL.Path, "/launch/") || strings.HasSuffix(req.URL.Path, "/ad_hoc_commands/"))
+}
+
+// RoundTrip sends a request unless the controller's circuit breaker is open,
+// recording whether the controller answered it
+func (t *controllerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c := t.controllerFor(req.URL.Host)
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	response, respErr := t.send(req, c)
+	c.breaker.record(req, response, respErr)
+	return response, respErr
+}
+
+func (t *controllerTransport) send(req *http.Request, c *controller) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := c.requests.wait(req.Context()); err != nil {
+			return nil, err
+		}
+		if isLaunch(req) {
+			if err := c.launches.wait(req.Context()); err != nil {
+				return nil, err
+			}
+		}
+
+		attemptReq := req
+		if attempt > 0 && req.GetBody != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return nil, bodyErr
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+
+		response, respErr := t.attempt(attemptReq)
+		if respErr != nil {
+			return nil, respErr
+		}
+		if response.StatusCode != http.StatusTooManyRequests && response.StatusCode != http.StatusServiceUnavailable {
+			return response, nil
+		}
+		if attempt >= ansibleMaxRetries || (req.Body != nil && req.GetBody == nil) {
+			return response, nil
+		}
+
+		backoff := retryBackoff(response, attempt)
+		io.Copy(io.Discard, response.Body)
+		response.Body.Close()
+		log.Printf("Controller returned %s for %s %s, retrying in %s", response.Status, req.Method, req.URL.Path, backoff)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// attempt sends one request, cancelling it if it takes longer than the
+// timeout, including reading the response body
+func (t *controllerTransport) attempt(req *http.Request) (*http.Response, error) {
+	if t.timeout == 0 {
+		return t.next.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	response, respErr := t.next.RoundTrip(req.WithContext(ctx))
+	if respErr != nil {
+		cancel()
+		return nil, respErr
+	}
+	response.Body = &cancelOnClose{ReadCloser: response.Body, cancel: cancel}
+	return response, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// retryBackoff honours a Retry-After given in seconds, otherwise doubling
+// from RetryBackoff with each attempt, up to MaxRetryBackoff
+func retryBackoff(response *http.Response, attempt int) time.Duration {
+	if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		backoff := time.Duration(seconds) * time.Second
+		if backoff > MaxRetryBackoff {
+			return MaxRetryBackoff
+		}
+		return backoff
+	}
+
+	backoff := RetryBackoff << attempt
+	if backoff > MaxRetryBackoff {
+		return MaxRetryBackoff
+	}
+	return backoff
+}
+
+func parseRateLimit(name string, value string, def float64) (float64, error) {
+	if len(value) == 0 {
+		return def, nil
+	}
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 {
+		return 0, fmt.Errorf("%s must be a number of requests per second, or 0 for no limit, not %q", name, value)
+	}
+	return rate, nil
+}
+
+func parseCount(name string, value string, def int) (int, error) {
+	if len(value) == 0 {
+		return def, nil
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 0 {
+		return 0, fmt.Errorf("%s must be a whole number, not %q", name, value)
+	}
+	return count, nil
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DefaultInventoryOutput = "ansible_inventory"
+	AllGroup               = "all"
+	UngroupedGroup         = "ungrouped"
+)
+
+// AnsibleHost is a host in an inventory. HasInventorySources is set by the
+// controller for hosts that came from an inventory source
+type AnsibleHost struct {
+	ID                  int    `json:"id,omitempty"`
+	Name                string `json:"name"`
+	Variables           string `json:"variables,omitempty"`
+	HasInventorySources bool   `json:"has_inventory_sources,omitempty"`
+}
+
+type AnsibleGroup struct {
+	ID                  int    `json:"id,omitempty"`
+	Name                string `json:"name"`
+	Variables           string `json:"variables,omitempty"`
+	HasInventorySources bool   `json:"has_inventory_sources,omitempty"`
+}
+
+type AnsibleAssociateRequest struct {
+	ID           int  `json:"id"`
+	Disassociate bool `json:"disassociate,omitempty"`
+}
+
+// desiredInventory is the inventory described by a Terraform output
+type desiredInventory struct {
+	Vars   map[string]any
+	Hosts  map[string]map[string]any
+	Groups map[string]*desiredGroup
+}
+
+type desiredGroup struct {
+	Vars     map[string]any
+	Hosts    []string
+	Children []string
+}
+
+type reconcileSummary struct {
+	HostsAdded    int
+	HostsUpdated  int
+	HostsRemoved  int
+	GroupsAdded   int
+	GroupsRemoved int
+}
+
+func (s reconcileSummary) String() string {
+	return fmt.Sprintf("%d hosts added, %d updated, %d removed; %d groups added, %d removed", s.HostsAdded, s.HostsUpdated, s.HostsRemoved, s.GroupsAdded, s.GroupsRemoved)
+}
+
+func asMap(value any, what string) (map[string]any, error) {
+	if value == nil {
+		return map[string]any{}, nil
+	}
+	m, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s must be a map", what)
+	}
+	return m, nil
+}
+
+// parseInventoryOutput reads an inventory output shaped as a map of group
+// names to hosts and vars, where hosts is either a list of host names or a
+// map of host names to host vars:
+//
+//	{ web = { hosts = { "web-1" = { ansible_host = "10.0.0.1" } }, vars = { http_port = 80 } } }
+//
+// Hosts in the "all" or "ungrouped" groups are not put in a group, and the
+// vars of "all" become inventory variables
+func parseInventoryOutput(value any) (*desiredInventory, error) {
+	groups, groupsErr := asMap(value, "the inventory output")
+	if groupsErr != nil {
+		return nil, groupsErr
+	}
+
+	desired := &desiredInventory{
+		Vars:   map[string]any{},
+		Hosts:  map[string]map[string]any{},
+		Groups: map[string]*desiredGroup{},
+	}
+
+	for groupName, groupValue := range groups {
+		group, groupErr := asMap(groupValue, fmt.Sprintf("group %s", groupName))
+		if groupErr != nil {
+			return nil, groupErr
+		}
+
+		groupVars, varsErr := asMap(group["vars"], fmt.Sprintf("vars of group %s", groupName))
+		if varsErr != nil {
+			return nil, varsErr
+		}
+
+		hosts := map[string]map[string]any{}
+		switch h := group["hosts"].(type) {
+		case nil:
+		case []any:
+			for _, host := range h {
+				hosts[fmt.Sprint(host)] = map[string]any{}
+			}
+		case map[string]any:
+			for hostName, hostValue := range h {
+				hostVars, hostErr := asMap(hostValue, fmt.Sprintf("vars of host %s", hostName))
+				if hostErr != nil {
+					return nil, hostErr
+				}
+				hosts[hostName] = hostVars
+			}
+		default:
+			return nil, fmt.Errorf("hosts of group %s must be a list or a map", groupName)
+		}
+
+		for hostName, hostVars := range hosts {
+			if _, ok := desired.Hosts[hostName]; !ok {
+				desired.Hosts[hostName] = map[string]any{}
+			}
+			for key, hostVar := range hostVars {
+				desired.Hosts[hostName][key] = hostVar
+			}
+		}
+
+		if groupName == AllGroup {
+			desired.Vars = groupVars
+			continue
+		}
+		if groupName == UngroupedGroup {
+			continue
+		}
+
+		desiredGroup := &desiredGroup{Vars: groupVars}
+		for hostName := range hosts {
+			desiredGroup.Hosts = append(desiredGroup.Hosts, hostName)
+		}
+		sort.Strings(desiredGroup.Hosts)
+		desired.Groups[groupName] = desiredGroup
+	}
+
+	return desired, nil
+}
+
+// normaliseVariables decodes AAP variables (YAML or JSON) or Terraform values
+// into a comparable form
+func normaliseVariables(variables any) any {
+	if s, ok := variables.(string); ok {
+		var decoded any
+		if yaml.Unmarshal([]byte(s), &decoded) != nil {
+			return s
+		}
+		variables = decoded
+	}
+
+	encoded, err := json.Marshal(variables)
+	if err != nil {
+		return variables
+	}
+	var normalised any
+	json.Unmarshal(encoded, &normalised)
+	if normalised == nil {
+		// no variables at all is the same as empty variables
+		normalised = map[string]any{}
+	}
+	return normalised
+}
+
+func variablesEqual(existing string, desired map[string]any) bool {
+	return reflect.DeepEqual(normaliseVariables(existing), normaliseVariables(desired))
+}
+
+func encodeVariables(variables map[string]any) (string, error) {
+	if len(variables) == 0 {
+		return "", nil
+	}
+	encoded, err := json.Marshal(variables)
+	return string(encoded), err
+}
+
+// reconcileInventory makes the hosts, groups, group membership and variables
+// of an inventory match the desired inventory, removing anything else
+func reconcileInventory(inventory *AnsibleInventoryResponse, desired *desiredInventory, ansibleAuth *AnsibleAuthResponse) (*reconcileSummary, error) {
+	summary := &reconcileSummary{}
+
+	if !variablesEqual(inventory.Variables, desired.Vars) {
+		variables, encodeErr := encodeVariables(desired.Vars)
+		if encodeErr != nil {
+			return nil, encodeErr
+		}
+		if err := ansibleAPIRequest(ansibleAuth, http.MethodPatch, fmt.Sprintf("/api/v2/inventories/%d/", inventory.ID), map[string]string{"variables": variables}, nil); err != nil {
+			return nil, fmt.Errorf("unable to update inventory variables: %w", err)
+		}
+	}
+
+	hostIDs, hostsErr := reconcileHosts(inventory.ID, desired.Hosts, summary, ansibleAuth)
+	if hostsErr != nil {
+		return nil, hostsErr
+	}
+
+	if groupsErr := reconcileGroups(inventory.ID, desired.Groups, hostIDs, summary, ansibleAuth); groupsErr != nil {
+		return nil, groupsErr
+	}
+
+	return summary, nil
+}
+
+// reconcileHosts returns the IDs of the desired hosts, keyed by name
+func reconcileHosts(inventoryID int, desired map[string]map[string]any, summary *reconcileSummary, ansibleAuth *AnsibleAuthResponse) (map[string]int, error) {
+	existing, listErr := ansibleListRequest[AnsibleHost](ansibleAuth, fmt.Sprintf("/api/v2/inventories/%d/hosts/?page_size=200", inventoryID))
+	if listErr != nil {
+		return nil, fmt.Errorf("unable to list hosts: %w", listErr)
+	}
+
+	hostIDs := make(map[string]int)
+	for _, host := range existing {
+		hostVars, ok := desired[host.Name]
+		if !ok {
+			// hosts from an inventory source belong to the source, not the output
+			if host.HasInventorySources {
+				continue
+			}
+			if err := ansibleAPIRequest(ansibleAuth, http.MethodDelete, fmt.Sprintf("/api/v2/hosts/%d/", host.ID), nil, nil); err != nil {
+				return nil, fmt.Errorf("unable to remove host %s: %w", host.Name, err)
+			}
+			summary.HostsRemoved++
+			continue
+		}
+
+		hostIDs[host.Name] = host.ID
+		if !variablesEqual(host.Variables, hostVars) {
+			variables, encodeErr := encodeVariables(hostVars)
+			if encodeErr != nil {
+				return nil, encodeErr
+			}
+			if err := ansibleAPIRequest(ansibleAuth, http.MethodPatch, fmt.Sprintf("/api/v2/hosts/%d/", host.ID), map[string]string{"variables": variables}, nil); err != nil {
+				return nil, fmt.Errorf("unable to update host %s: %w", host.Name, err)
+			}
+			summary.HostsUpdated++
+		}
+	}
+
+	for hostName, hostVars := range desired {
+		if _, ok := hostIDs[hostName]; ok {
+			continue
+		}
+
+		variables, encodeErr := encodeVariables(hostVars)
+		if encodeErr != nil {
+			return nil, encodeErr
+		}
+		var created AnsibleHost
+		if err := ansibleAPIRequest(ansibleAuth, http.MethodPost, fmt.Sprintf("/api/v2/inventories/%d/hosts/", inventoryID), AnsibleHost{Name: hostName, Variables: variables}, &created); err != nil {
+			return nil, fmt.Errorf("unable to add host %s: %w", hostName, err)
+		}
+		hostIDs[hostName] = created.ID
+		summary.HostsAdded++
+	}
+
+	return hostIDs, nil
+}
+
+func reconcileGroups(inventoryID int, desired map[string]*desiredGroup, hostIDs map[string]int, summary *reconcileSummary, ansibleAuth *AnsibleAuthResponse) error {
+	existing, listErr := ansibleListRequest[AnsibleGroup](ansibleAuth, fmt.Sprintf("/api/v2/inventories/%d/groups/?page_size=200", inventoryID))
+	if listErr != nil {
+		return fmt.Errorf("unable to list groups: %w", listErr)
+	}
+
+	groupIDs := make(map[string]int)
+	for _, group := range existing {
+		desiredGroup, ok := desired[group.Name]
+		if !ok {
+			if group.HasInventorySources {
+				continue
+			}
+			if err := ansibleAPIRequest(ansibleAuth, http.MethodDelete, fmt.Sprintf("/api/v2/groups/%d/", group.ID), nil, nil); err != nil {
+				return fmt.Errorf("unable to remove group %s: %w", group.Name, err)
+			}
+			summary.GroupsRemoved++
+			continue
+		}
+
+		groupIDs[group.Name] = group.ID
+		if !variablesEqual(group.Variables, desiredGroup.Vars) {
+			variables, encodeErr := encodeVariables(desiredGroup.Vars)
+			if encodeErr != nil {
+				return encodeErr
+			}
+			if err := ansibleAPIRequest(ansibleAuth, http.MethodPatch, fmt.Sprintf("/api/v2/groups/%d/", group.ID), map[string]string{"variables": variables}, nil); err != nil {
+				return fmt.Errorf("unable to update group %s: %w", group.Name, err)
+			}
+		}
+	}
+
+	for groupName, desiredGroup := range desired {
+		if _, ok := groupIDs[groupName]; ok {
+			continue
+		}
+
+		variables, encodeErr := encodeVariables(desiredGroup.Vars)
+		if encodeErr != nil {
+			return encodeErr
+		}
+		var created AnsibleGroup
+		if err := ansibleAPIRequest(ansibleAuth, http.MethodPost, fmt.Sprintf("/api/v2/inventories/%d/groups/", inventoryID), AnsibleGroup{Name: groupName, Variables: variables}, &created); err != nil {
+			return fmt.Errorf("unable to add group %s: %w", groupName, err)
+		}
+		groupIDs[groupName] = created.ID
+		summary.GroupsAdded++
+	}
+
+	for groupName, desiredGroup := range desired {
+		if err := reconcileGroupMembers(groupName, fmt.Sprintf("/api/v2/groups/%d/hosts/", groupIDs[groupName]), "host", desiredGroup.Hosts, hostIDs, ansibleAuth); err != nil {
+			return err
+		}
+		if err := reconcileGroupMembers(groupName, fmt.Sprintf("/api/v2/groups/%d/children/", groupIDs[groupName]), "child group", desiredGroup.Children, groupIDs, ansibleAuth); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reconcileGroupMembers makes the hosts or child groups of a group, listed and
+// associated at path, match the desired names
+func reconcileGroupMembers(groupName string, path string, memberType string, desired []string, memberIDs map[string]int, ansibleAuth *AnsibleAuthResponse) error {
+	members, listErr := ansibleListRequest[AnsibleHost](ansibleAuth, path+"?page_size=200")
+	if listErr != nil {
+		return fmt.Errorf("unable to list %ss of group %s: %w", memberType, groupName, listErr)
+	}
+
+	wanted := make(map[string]bool)
+	for _, memberName := range desired {
+		wanted[memberName] = true
+	}
+
+	var problems []string
+	for _, member := range members {
+		if wanted[member.Name] {
+			delete(wanted, member.Name)
+			continue
+		}
+		if err := ansibleAPIRequest(ansibleAuth, http.MethodPost, path, AnsibleAssociateRequest{ID: member.ID, Disassociate: true}, nil); err != nil {
+			problems = append(problems, fmt.Sprintf("unable to remove %s %s from group %s: %s", memberType, member.Name, groupName, err))
+		}
+	}
+
+	for memberName := range wanted {
+		if err := ansibleAPIRequest(ansibleAuth, http.MethodPost, path, AnsibleAssociateRequest{ID: memberIDs[memberName]}, nil); err != nil {
+			problems = append(problems, fmt.Sprintf("unable to add %s %s to group %s: %s", memberType, memberName, groupName, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// ansibleSyncInventoryRequest reconciles the Workspace's inventory, creating it
+// if needed, with the inventory output of the Workspace's current state
+func ansibleSyncInventoryRequest(request RunTaskRequest, organisation int, action ActionConfig, ansibleAuth *AnsibleAuthResponse) (*AnsibleInventoryResponse, *reconcileSummary, error) {
+	outputName := action.Inventory.Output
+	if len(outputName) == 0 {
+		outputName = DefaultInventoryOutput
+	}
+
+	outputs, outputsErr := tfcStateOutputs(request)
+	if outputsErr != nil {
+		return nil, nil, outputsErr
+	}
+
+	value, ok := outputs[outputName]
+	if !ok {
+		return nil, nil, fmt.Errorf("the Workspace state has no non-sensitive %s output to synchronise the inventory from", outputName)
+	}
+
+	desired, parseErr := parseInventoryOutput(value)
+	if parseErr != nil {
+		return nil, nil, fmt.Errorf("unable to read output %s: %w", outputName, parseErr)
+	}
+
+	// the output's own inventory variables take precedence
+	variables, variablesErr := inventoryVariables(action.Inventory.Variables, newTemplateData(request))
+	if variablesErr != nil {
+		return nil, nil, variablesErr
+	}
+	desired.Vars = mergeExtraVars(variables, desired.Vars)
+
+	if action.Inventory.GroupTree.enabled() {
+		tree, treeErr := groupTree(request, action.Inventory.GroupTree, desired.Hosts)
+		if treeErr != nil {
+			return nil, nil, treeErr
+		}
+		mergeGroupTree(desired.Groups, tree)
+	}
+
+	name, nameErr := inventoryName(newTemplateData(request), action)
+	if nameErr != nil {
+		return nil, nil, nameErr
+	}
+
+	inventory, findErr := ansibleFindInventory(name, organisation, ansibleAuth)
+	if findErr != nil {
+		return nil, nil, findErr
+	}
+	if inventory == nil {
+		var createErr error
+		inventory, createErr = ansibleCreateInventoryRequest(request, organisation, action, ansibleAuth)
+		if createErr != nil {
+			return nil, nil, createErr
+		}
+	}
+
+	if len(inventory.Kind) > 0 {
+		return inventory, nil, fmt.Errorf("Ansible Inventory %s is a %s inventory, so its hosts cannot be synchronised", inventory.Name, inventory.Kind)
+	}
+
+	summary, reconcileErr := reconcileInventory(inventory, desired, ansibleAuth)
+	if reconcileErr != nil {
+		return inventory, nil, reconcileErr
+	}
+
+	return inventory, summary, nil
+}
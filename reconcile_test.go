@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestReconcileHostsKeepsSourcedHosts(t *testing.T) {
+	var deleted []string
+	auth := newTestController(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v2/inventories/8/hosts/":
+			w.Write([]byte(`{"count": 3, "results": [
+				{"id": 1, "name": "web-1"},
+				{"id": 2, "name": "web-2"},
+				{"id": 3, "name": "db-1", "has_inventory_sources": true}
+			]}`))
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	var summary reconcileSummary
+	hostIDs, err := reconcileHosts(8, map[string]map[string]any{"web-1": {}}, &summary, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0] != "/api/v2/hosts/2/" {
+		t.Errorf("expected only web-2 to be removed, removed %v", deleted)
+	}
+	if hostIDs["web-1"] != 1 || summary.HostsRemoved != 1 {
+		t.Errorf("expected web-1 to be kept and one host removed, got %v and %+v", hostIDs, summary)
+	}
+}
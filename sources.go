@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// InventorySourceConfig describes an inventory source, such as a cloud
+// inventory plugin, to add to the workspace inventory. Credential is an ID
+// or the name of a credential
+type InventorySourceConfig struct {
+	Name                 string         `yaml:"name,omitempty"`
+	Source               string         `yaml:"source,omitempty"`
+	Credential           string         `yaml:"credential,omitempty"`
+	SourceVars           map[string]any `yaml:"source_vars,omitempty"`
+	ExecutionEnvironment int            `yaml:"execution_environment,omitempty"`
+	Overwrite            bool           `yaml:"overwrite,omitempty"`
+	OverwriteVars        bool           `yaml:"overwrite_vars,omitempty"`
+	UpdateOnLaunch       bool           `yaml:"update_on_launch,omitempty"`
+}
+
+type AnsibleInventorySource struct {
+	ID                   int    `json:"id,omitempty"`
+	Name                 string `json:"name"`
+	Inventory            int    `json:"inventory"`
+	Source               string `json:"source"`
+	SourceVars           string `json:"source_vars,omitempty"`
+	Credential           int    `json:"credential,omitempty"`
+	ExecutionEnvironment int    `json:"execution_environment,omitempty"`
+	Overwrite            bool   `json:"overwrite"`
+	OverwriteVars        bool   `json:"overwrite_vars"`
+	UpdateOnLaunch       bool   `json:"update_on_launch"`
+}
+
+type AnsibleCredential struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type AnsibleInventorySourceUpdate struct {
+	InventoryUpdate int    `json:"inventory_update"`
+	InventorySource int    `json:"inventory_source"`
+	Status          string `json:"status"`
+}
+
+// ansibleFindCredential resolves a credential ID or name to an ID
+func ansibleFindCredential(credential string, organisation int, ansibleAuth *AnsibleAuthResponse) (int, error) {
+	if id, convErr := strconv.Atoi(credential); convErr == nil {
+		return id, nil
+	}
+
+	query := url.Values{}
+	query.Set("name", credential)
+	if organisation != 0 {
+		query.Set("organization", fmt.Sprint(organisation))
+	}
+
+	credentials, listErr := ansibleListRequest[AnsibleCredential](ansibleAuth, "/api/v2/credentials/?"+query.Encode())
+	if listErr != nil {
+		return 0, listErr
+	}
+
+	switch len(credentials) {
+	case 0:
+		return 0, fmt.Errorf("no Ansible Credential named %s", credential)
+	case 1:
+		return credentials[0].ID, nil
+	default:
+		return 0, fmt.Errorf("found %d Ansible Credentials named %s, use its ID instead", len(credentials), credential)
+	}
+}
+
+func buildInventorySource(config InventorySourceConfig, inventory *AnsibleInventoryResponse, data *TemplateData, organisation int, ansibleAuth *AnsibleAuthResponse) (*AnsibleInventorySource, error) {
+	name, nameErr := resolveActionValue("inventory source name", config.Name, data)
+	if nameErr != nil {
+		return nil, nameErr
+	}
+	if len(name) == 0 {
+		name = fmt.Sprintf("%s %s", inventory.Name, config.Source)
+	}
+
+	var source AnsibleInventorySource
+	source.Name = name
+	source.Inventory = inventory.ID
+	source.Source = config.Source
+	source.ExecutionEnvironment = config.ExecutionEnvironment
+	source.Overwrite = config.Overwrite
+	source.OverwriteVars = config.OverwriteVars
+	source.UpdateOnLaunch = config.UpdateOnLaunch
+
+	if len(config.SourceVars) > 0 {
+		rendered, renderErr := renderValues(fmt.Sprintf("inventory source %s source_vars", name), config.SourceVars, data)
+		if renderErr != nil {
+			return nil, renderErr
+		}
+		encoded, yamlErr := yaml.Marshal(rendered)
+		if yamlErr != nil {
+			return nil, yamlErr
+		}
+		source.SourceVars = string(encoded)
+	}
+
+	if len(config.Credential) > 0 {
+		credential, credentialErr := ansibleFindCredential(config.Credential, organisation, ansibleAuth)
+		if credentialErr != nil {
+			return nil, credentialErr
+		}
+		source.Credential = credential
+	}
+
+	return &source, nil
+}
+
+// ansibleSyncInventorySourcesRequest creates or updates the action's
+// inventory sources on an inventory, then updates them all and waits for
+// the updates to finish
+func ansibleSyncInventorySourcesRequest(request RunTaskRequest, inventory *AnsibleInventoryResponse, organisation int, action ActionConfig, ansibleAuth *AnsibleAuthResponse) error {
+	data := newTemplateData(request)
+
+	sourceNames := make(map[int]string)
+	for _, config := range action.Inventory.Sources {
+		source, buildErr := buildInventorySource(config, inventory, data, organisation, ansibleAuth)
+		if buildErr != nil {
+			return buildErr
+		}
+
+		query := url.Values{}
+		query.Set("name", source.Name)
+		existing, listErr := ansibleListRequest[AnsibleInventorySource](ansibleAuth, fmt.Sprintf("/api/v2/inventories/%d/inventory_sources/?%s", inventory.ID, query.Encode()))
+		if listErr != nil {
+			return listErr
+		}
+
+		if len(existing) > 0 {
+			path := fmt.Sprintf("/api/v2/inventory_sources/%d/", existing[0].ID)
+			if err := ansibleAPIRequest(ansibleAuth, http.MethodPatch, path, source, nil); err != nil {
+				return fmt.Errorf("unable to update inventory source %s: %w", source.Name, err)
+			}
+			sourceNames[existing[0].ID] = source.Name
+			continue
+		}
+
+		var created AnsibleInventorySource
+		if err := ansibleAPIRequest(ansibleAuth, http.MethodPost, "/api/v2/inventory_sources/", source, &created); err != nil {
+			return fmt.Errorf("unable to create inventory source %s: %w", source.Name, err)
+		}
+		sourceNames[created.ID] = source.Name
+	}
+
+	var updates []AnsibleInventorySourceUpdate
+	if err := ansibleAPIRequest(ansibleAuth, http.MethodPost, fmt.Sprintf("/api/v2/inventories/%d/update_inventory_sources/", inventory.ID), nil, &updates); err != nil {
+		return fmt.Errorf("unable to update the inventory sources of Ansible Inventory %s: %w", inventory.Name, err)
+	}
+
+	// sources that could not start, e.g. because an update is already
+	// running, are listed with a reason as their status and no update
+	var notStarted []string
+	for _, update := range updates {
+		if update.InventoryUpdate != 0 {
+			continue
+		}
+		name, ok := sourceNames[update.InventorySource]
+		if !ok {
+			name = strconv.Itoa(update.InventorySource)
+		}
+		notStarted = append(notStarted, fmt.Sprintf("inventory source %s could not be updated: %s", name, update.Status))
+	}
+	if len(notStarted) > 0 {
+		return errors.New(strings.Join(notStarted, "; "))
+	}
+
+	var failures []string
+	for _, update := range updates {
+		job, waitErr := ansibleWaitForJob(fmt.Sprintf("/api/v2/inventory_updates/%d/", update.InventoryUpdate), nil, ansibleAuth)
+		if waitErr != nil {
+			return waitErr
+		}
+		if resultErr := job.result(); resultErr != nil {
+			failures = append(failures, resultErr.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("inventory source sync failed: %s", strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+func inventorySourcesMessage(action ActionConfig) string {
+	if len(action.Inventory.Sources) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", synced %d inventory sources", len(action.Inventory.Sources))
+}
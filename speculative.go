@@ -0,0 +1,87 @@
+package main
+
+import "fmt"
+
+const (
+	SpeculativeRun     = "run"
+	SpeculativeSkip    = "skip"
+	SpeculativeCheck   = "check"
+	SpeculativeSandbox = "sandbox"
+)
+
+// SpeculativeConfig sets what an action does for speculative plans, such as
+// those for pull requests. Policy is run (the default), skip, check, or
+// sandbox to launch against Inventory instead
+type SpeculativeConfig struct {
+	Policy    string `yaml:"policy,omitempty"`
+	Inventory string `yaml:"inventory,omitempty"`
+}
+
+// checkSpeculativeConfig checks a speculative policy, and that the endpoint,
+// if known, can use it. Workflows have no check mode, and the inventory
+// endpoint launches nothing to check or sandbox
+func checkSpeculativeConfig(speculative SpeculativeConfig, endpoint string) error {
+	switch {
+	case endpoint == WorkflowEndpoint && speculative.Policy == SpeculativeCheck:
+		return fmt.Errorf("the %s speculative policy cannot be used with a Workflow Job Template", SpeculativeCheck)
+	case endpoint == InventoryEndpoint && (speculative.Policy == SpeculativeCheck || speculative.Policy == SpeculativeSandbox):
+		return fmt.Errorf("the %s speculative policy cannot be used with the inventory endpoint, which can only %s or %s", speculative.Policy, SpeculativeRun, SpeculativeSkip)
+	}
+
+	switch speculative.Policy {
+	case "", SpeculativeRun, SpeculativeSkip, SpeculativeCheck:
+		if len(speculative.Inventory) > 0 {
+			return fmt.Errorf("speculative inventory can only be set with the %s policy", SpeculativeSandbox)
+		}
+		return nil
+	case SpeculativeSandbox:
+		if len(speculative.Inventory) == 0 {
+			return fmt.Errorf("speculative inventory must be set with the %s policy", SpeculativeSandbox)
+		}
+		return nil
+	default:
+		return fmt.Errorf("speculative policy must be %s, %s, %s or %s, not %q", SpeculativeRun, SpeculativeSkip, SpeculativeCheck, SpeculativeSandbox, speculative.Policy)
+	}
+}
+
+// speculativePolicy returns the policy applying to a Run Task, which is
+// always run for plans that can be applied
+func speculativePolicy(request RunTaskRequest, action ActionConfig) string {
+	if !request.IsSpeculative || len(action.Speculative.Policy) == 0 {
+		return SpeculativeRun
+	}
+	return action.Speculative.Policy
+}
+
+// speculativeAction changes an action's launch to follow its speculative
+// policy
+func speculativeAction(request RunTaskRequest, action ActionConfig) ActionConfig {
+	switch speculativePolicy(request, action) {
+	case SpeculativeCheck:
+		action.Launch.JobType = CheckJobType
+		action.AdHoc.JobType = CheckJobType
+	case SpeculativeSandbox:
+		action.Launch.Inventory = action.Speculative.Inventory
+	}
+	return action
+}
+
+// speculativeSkippedMessage says why a speculative plan did nothing
+func speculativeSkippedMessage(skipped string) string {
+	return fmt.Sprintf("Speculative plan, skipped %s as the action's speculative policy is %s", skipped, SpeculativeSkip)
+}
+
+// speculativeMessage says how the speculative policy changed the launch
+func speculativeMessage(request RunTaskRequest, action ActionConfig) string {
+	if !request.IsSpeculative {
+		return ""
+	}
+	switch speculativePolicy(request, action) {
+	case SpeculativeCheck:
+		return fmt.Sprintf(". Speculative plan, launched in check mode as the action's speculative policy is %s", SpeculativeCheck)
+	case SpeculativeSandbox:
+		return fmt.Sprintf(". Speculative plan, launched against inventory %s as the action's speculative policy is %s", action.Speculative.Inventory, SpeculativeSandbox)
+	default:
+		return fmt.Sprintf(". Speculative plan, launched as normal as the action's speculative policy is %s", SpeculativeRun)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type AnsibleSurveySpec struct {
+	Name        string                  `json:"name,omitempty"`
+	Description string                  `json:"description,omitempty"`
+	Spec        []AnsibleSurveyQuestion `json:"spec,omitempty"`
+}
+
+type AnsibleSurveyQuestion struct {
+	QuestionName string   `json:"question_name"`
+	Variable     string   `json:"variable"`
+	Type         string   `json:"type"`
+	Required     bool     `json:"required"`
+	Min          *float64 `json:"min,omitempty"`
+	Max          *float64 `json:"max,omitempty"`
+	Choices      any      `json:"choices,omitempty"`
+}
+
+// choices returns the question's choices, which AAP returns either as a list
+// or as a newline separated string
+func (q AnsibleSurveyQuestion) choices() []string {
+	var choices []string
+	switch c := q.Choices.(type) {
+	case string:
+		for _, choice := range strings.Split(c, "\n") {
+			if len(strings.TrimSpace(choice)) > 0 {
+				choices = append(choices, choice)
+			}
+		}
+	case []any:
+		for _, choice := range c {
+			choices = append(choices, fmt.Sprint(choice))
+		}
+	}
+	return choices
+}
+
+func (q AnsibleSurveyQuestion) checkRange(value float64, what string) error {
+	if q.Min != nil && value < *q.Min {
+		return fmt.Errorf("%s must be at least %v", what, *q.Min)
+	}
+	if q.Max != nil && value > *q.Max {
+		return fmt.Errorf("%s must be at most %v", what, *q.Max)
+	}
+	return nil
+}
+
+func (q AnsibleSurveyQuestion) isChoice(answer string) bool {
+	for _, choice := range q.choices() {
+		if choice == answer {
+			return true
+		}
+	}
+	return false
+}
+
+// answer converts a rendered answer to the type the question expects,
+// checking it against the question's limits and choices. Multiselect answers
+// are comma separated
+func (q AnsibleSurveyQuestion) answer(answer string) (any, error) {
+	switch q.Type {
+	case "integer":
+		value, err := strconv.Atoi(strings.TrimSpace(answer))
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", answer)
+		}
+		return value, q.checkRange(float64(value), "value")
+	case "float":
+		value, err := strconv.ParseFloat(strings.TrimSpace(answer), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", answer)
+		}
+		return value, q.checkRange(value, "value")
+	case "multiplechoice":
+		if !q.isChoice(answer) {
+			return nil, fmt.Errorf("%q is not one of %s", answer, strings.Join(q.choices(), ", "))
+		}
+		return answer, nil
+	case "multiselect":
+		var selected []string
+		for _, choice := range strings.Split(answer, ",") {
+			choice = strings.TrimSpace(choice)
+			if len(choice) == 0 {
+				continue
+			}
+			if !q.isChoice(choice) {
+				return nil, fmt.Errorf("%q is not one of %s", choice, strings.Join(q.choices(), ", "))
+			}
+			selected = append(selected, choice)
+		}
+		return selected, nil
+	default:
+		// text, textarea and password answers are limited by length
+		return answer, q.checkRange(float64(utf8.RuneCountInString(answer)), "length")
+	}
+}
+
+func ansibleSurveySpecRequest(path string, ansibleAuth *AnsibleAuthResponse) (*AnsibleSurveySpec, error) {
+	var spec AnsibleSurveySpec
+	if err := ansibleAPIRequest(ansibleAuth, http.MethodGet, path, nil, &spec); err != nil {
+		return nil, fmt.Errorf("unable to read survey: %w", err)
+	}
+	return &spec, nil
+}
+
+// surveyAnswers renders an action's survey answers and validates them
+// against the template's survey spec, returning them as extra_vars
+func surveyAnswers(data *TemplateData, survey map[string]string, surveyEnabled bool, specPath string, ansibleAuth *AnsibleAuthResponse) (map[string]any, error) {
+	if len(survey) == 0 {
+		return nil, nil
+	}
+	if !surveyEnabled {
+		return nil, fmt.Errorf("the action supplies survey answers, but the template does not have a survey enabled")
+	}
+
+	spec, specErr := ansibleSurveySpecRequest(specPath, ansibleAuth)
+	if specErr != nil {
+		return nil, specErr
+	}
+
+	questions := make(map[string]AnsibleSurveyQuestion)
+	for _, question := range spec.Spec {
+		questions[question.Variable] = question
+	}
+
+	answers := make(map[string]any)
+	for variable, answerTemplate := range survey {
+		question, ok := questions[variable]
+		if !ok {
+			return nil, fmt.Errorf("the survey has no question for variable %s", variable)
+		}
+
+		rendered, renderErr := renderTemplate(fmt.Sprintf("survey answer %s", variable), answerTemplate, data)
+		if renderErr != nil {
+			return nil, renderErr
+		}
+
+		if len(rendered) == 0 && !question.Required {
+			continue
+		}
+
+		answer, answerErr := question.answer(rendered)
+		if answerErr != nil {
+			return nil, fmt.Errorf("invalid survey answer for %s (%s): %w", variable, question.QuestionName, answerErr)
+		}
+		answers[variable] = answer
+	}
+
+	return answers, nil
+}
+
+// mergeExtraVars returns a new map of extra_vars with the overrides applied,
+// leaving the action's configured extra_vars untouched
+func mergeExtraVars(extraVars map[string]any, overrides map[string]any) map[string]any {
+	if len(overrides) == 0 {
+		return extraVars
+	}
+
+	merged := make(map[string]any, len(extraVars)+len(overrides))
+	for key, value := range extraVars {
+		merged[key] = value
+	}
+	for key, value := range overrides {
+		merged[key] = value
+	}
+	return merged
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	MaxTargetedMessageHosts = 10
+)
+
+// TargetConfig limits a launch to the hosts whose resources the run's plan
+// creates, updates or replaces. Host is a template rendered for each changed
+// resource, which is available as {{ .Resource }}
+type TargetConfig struct {
+	Host          string   `yaml:"host,omitempty"`
+	ResourceTypes []string `yaml:"resource_types,omitempty"`
+	ExtraVar      string   `yaml:"extra_var,omitempty"`
+}
+
+func (t TargetConfig) enabled() bool {
+	return len(t.Host) > 0
+}
+
+func (t TargetConfig) targetsType(resourceType string) bool {
+	if len(t.ResourceTypes) == 0 {
+		return true
+	}
+	for _, targetType := range t.ResourceTypes {
+		if targetType == resourceType {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTargetConfig rejects a target that would replace the launch's own
+// limit, which only an extra_var target leaves in place
+func checkTargetConfig(target TargetConfig, launch LaunchConfig) error {
+	if target.enabled() && len(target.ExtraVar) == 0 && len(launch.Limit) > 0 {
+		return fmt.Errorf("target sets the launch's limit, so launch limit cannot also be set unless target extra_var is")
+	}
+	return nil
+}
+
+// isTargetChange reports whether a resource change creates, updates or
+// replaces a resource, rather than only deleting or reading it
+func isTargetChange(change TFCResourceChange) bool {
+	if change.Mode != "managed" {
+		return false
+	}
+	for _, action := range change.Change.Actions {
+		if action == "create" || action == "update" {
+			return true
+		}
+	}
+	return false
+}
+
+// planTargetHosts returns the sorted, de-duplicated host names of the
+// resources changed by the run's plan
+func planTargetHosts(request RunTaskRequest, target TargetConfig) ([]string, error) {
+	plan, planErr := tfcPlanJSON(request)
+	if planErr != nil {
+		return nil, planErr
+	}
+
+	data := newTemplateData(request)
+	seen := make(map[string]bool)
+	var hosts []string
+	for i := range plan.ResourceChanges {
+		change := &plan.ResourceChanges[i]
+		if !isTargetChange(*change) || !target.targetsType(change.Type) {
+			continue
+		}
+
+		data.Resource = change
+		host, renderErr := renderResourceTemplate(fmt.Sprintf("target host of %s", change.Address), target.Host, data)
+		if renderErr != nil {
+			return nil, renderErr
+		}
+
+		host = strings.TrimSpace(host)
+		if len(host) == 0 || seen[host] {
+			continue
+		}
+		seen[host] = true
+		hosts = append(hosts, host)
+	}
+
+	sort.Strings(hosts)
+	return hosts, nil
+}
+
+// targetedAction returns a copy of an action that launches against hosts,
+// either as the limit or as a list in the configured extra var
+func targetedAction(action ActionConfig, hosts []string) ActionConfig {
+	// host names come from the plan, so they are not rendered as templates
+	if len(action.Target.ExtraVar) == 0 {
+		action.Launch.setLiteral("limit", strings.Join(hosts, ":"))
+		return action
+	}
+
+	values := make([]any, len(hosts))
+	for i, host := range hosts {
+		values[i] = host
+	}
+	action.Launch.setLiteralExtraVar(action.Target.ExtraVar, values)
+	return action
+}
+
+// targetedMessage describes the hosts a targeted launch was limited to,
+// naming no more than MaxTargetedMessageHosts of them
+func targetedMessage(action ActionConfig, hosts []string) string {
+	if !action.Target.enabled() {
+		return ""
+	}
+	if len(hosts) > MaxTargetedMessageHosts {
+		return fmt.Sprintf(", targeting %d changed hosts: %s and %d more", len(hosts), strings.Join(hosts[:MaxTargetedMessageHosts], ", "), len(hosts)-MaxTargetedMessageHosts)
+	}
+	return fmt.Sprintf(", targeting %d changed hosts: %s", len(hosts), strings.Join(hosts, ", "))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const targetTestPlan = `{"resource_changes": [
+	{"address": "aws_instance.web", "mode": "managed", "type": "aws_instance", "change": {"actions": ["create"], "after": {"tags": {"Name": "web-1"}}}},
+	{"address": "aws_instance.untagged", "mode": "managed", "type": "aws_instance", "change": {"actions": ["create"], "after": {"tags": null}}},
+	{"address": "aws_instance.unnamed", "mode": "managed", "type": "aws_instance", "change": {"actions": ["update"], "after": {"tags": {"Role": "db"}}}}
+]}`
+
+func servePlan(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(targetTestPlan))
+}
+
+func TestTargetRunTaskSkipsResourcesWithoutHost(t *testing.T) {
+	request := newTestTFC(t, servePlan)
+	action := ActionConfig{Target: TargetConfig{Host: "{{ .Resource.Change.After.tags.Name }}"}}
+
+	targeted, hosts, response := targetRunTask(request, action, JobEndpoint)
+	if response != nil {
+		t.Fatalf("expected a launch, got %s: %s", response.Data.Attributes.Status, response.Data.Attributes.Message)
+	}
+	if !reflect.DeepEqual(hosts, []string{"web-1"}) {
+		t.Errorf("expected hosts [web-1], got %v", hosts)
+	}
+	jtReq, buildErr := buildJobTemplateRequest(newTemplateData(request), targeted, nil)
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+	if jtReq.Limit != "web-1" {
+		t.Errorf("expected limit web-1, got %q", jtReq.Limit)
+	}
+}
+
+func TestTargetedHostsAreNotRendered(t *testing.T) {
+	hosts := []string{"web-{{ .Oops }}"}
+
+	limited := targetedAction(ActionConfig{}, hosts)
+	jtReq, err := buildJobTemplateRequest(newTemplateData(RunTaskRequest{}), limited, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jtReq.Limit != hosts[0] {
+		t.Errorf("expected limit %q, got %q", hosts[0], jtReq.Limit)
+	}
+
+	action := ActionConfig{Target: TargetConfig{ExtraVar: "target_hosts"}}
+	action.Launch.ExtraVars = map[string]any{"target_hosts": "{{ .Oops }}", "region": "eu-west-1"}
+	listed := targetedAction(action, hosts)
+	jtReq, err = buildJobTemplateRequest(newTemplateData(RunTaskRequest{}), listed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]any{"target_hosts": []any{hosts[0]}, "region": "eu-west-1"}
+	if !reflect.DeepEqual(jtReq.ExtraVars, expected) {
+		t.Errorf("expected extra vars %v, got %v", expected, jtReq.ExtraVars)
+	}
+}
+
+func TestLoadActionsRejectsTargetWithLimit(t *testing.T) {
+	_, err := loadTestActions(t, `
+actions:
+  deploy:
+    launch:
+      limit: web
+    target:
+      host: "{{ .Resource.Change.After.tags.Name }}"
+`)
+	if err == nil || !strings.Contains(err.Error(), "launch limit cannot also be set") {
+		t.Fatalf("expected target and limit to be rejected, got %v", err)
+	}
+
+	if _, err := loadTestActions(t, `
+actions:
+  deploy:
+    launch:
+      limit: web
+    target:
+      host: "{{ .Resource.Change.After.tags.Name }}"
+      extra_var: target_hosts
+`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTargetRunTaskFailsOnTemplateErrors(t *testing.T) {
+	tests := map[string]string{
+		"misspelt field": "{{ .Resource.Chnage.After.tags.Name }}",
+		"bad pattern":    `{{ .Resource.Change.After.tags.Name | regexReplace "(" "" }}`,
+	}
+
+	for name, host := range tests {
+		t.Run(name, func(t *testing.T) {
+			request := newTestTFC(t, servePlan)
+			action := ActionConfig{Target: TargetConfig{Host: host}}
+
+			_, _, response := targetRunTask(request, action, JobEndpoint)
+			if response == nil {
+				t.Fatal("expected the Run Task to fail, but it launched")
+			}
+			if response.Data.Attributes.Status != Failed {
+				t.Fatalf("expected the Run Task to fail, got %s: %s", response.Data.Attributes.Status, response.Data.Attributes.Message)
+			}
+			if !strings.Contains(response.Data.Attributes.Message, "aws_instance.web") {
+				t.Errorf("expected the message to name the resource, got %q", response.Data.Attributes.Message)
+			}
+		})
+	}
+}
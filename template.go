@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const (
+	PrePlan   = "pre_plan"
+	PostPlan  = "post_plan"
+	PreApply  = "pre_apply"
+	PostApply = "post_apply"
+)
+
+const (
+	TemplateEnvPrefix = "ARTS_VAR_"
+)
+
+var nonSlugCharacters = regexp.MustCompile(`[^a-z0-9]+`)
+
+var templateFuncs = template.FuncMap{
+	"lower":        strings.ToLower,
+	"upper":        strings.ToUpper,
+	"trim":         strings.TrimSpace,
+	"replace":      templateReplace,
+	"join":         templateJoin,
+	"slug":         templateSlug,
+	"regexReplace": templateRegexReplace,
+	"default":      templateDefault,
+	"toJSON":       templateToJSON,
+	"env":          templateEnv,
+}
+
+// TemplateData is what action templates are rendered with. The Run Task
+// payload fields are available directly e.g. {{ .WorkspaceName }}, along with
+// {{ .Variables }} and {{ .Outputs }}, which are fetched from TFC/TFE when a
+// template first uses them, and the functions in templateFuncs
+type TemplateData struct {
+	RunTaskRequest
+
+	// set when rendering an action's message
+	Status  string
+	Message string
+
+	// set when rendering a target host
+	Resource *TFCResourceChange
+
+	request   RunTaskRequest
+	variables map[string]any
+	outputs   map[string]any
+}
+
+func newTemplateData(request RunTaskRequest) *TemplateData {
+	data := &TemplateData{
+		RunTaskRequest: request,
+		request:        request,
+	}
+	// templates must not be able to publish the token
+	data.RunTaskRequest.AccessToken = ""
+	return data
+}
+
+// Variables returns the Workspace's non-sensitive Terraform variables
+func (d *TemplateData) Variables() (map[string]any, error) {
+	if d.variables == nil {
+		variables, err := tfcWorkspaceVariables(d.request)
+		if err != nil {
+			return nil, err
+		}
+		d.variables = variables
+	}
+	return d.variables, nil
+}
+
+// Outputs returns the non-sensitive outputs of the Workspace's current state,
+// which are only up to date for post-apply Run Tasks
+func (d *TemplateData) Outputs() (map[string]any, error) {
+	if d.request.Stage != PostApply {
+		return nil, fmt.Errorf("state outputs are only available to %s Run Tasks", PostApply)
+	}
+	if d.outputs == nil {
+		outputs, err := tfcStateOutputs(d.request)
+		if err != nil {
+			return nil, err
+		}
+		d.outputs = outputs
+	}
+	return d.outputs, nil
+}
+
+func renderTemplate(name string, text string, data *TemplateData) (string, error) {
+	// skip parsing plain values
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	tmpl, parseErr := template.New(name).Option("missingkey=error").Funcs(templateFuncs).Parse(text)
+	if parseErr != nil {
+		return "", fmt.Errorf("unable to parse template for %s: %s", name, parseErr)
+	}
+
+	var sb strings.Builder
+	if execErr := tmpl.Execute(&sb, data); execErr != nil {
+		return "", fmt.Errorf("unable to render template for %s: %s", name, execErr)
+	}
+
+	return sb.String(), nil
+}
+
+// renderResourceTemplate renders a template for one resource in the run's
+// plan. Resources differ in their attributes, so a template that stops at an
+// attribute the resource does not have, or that is null, renders empty. Any
+// other error, such as a misspelt field or a bad pattern, is returned
+func renderResourceTemplate(name string, text string, data *TemplateData) (string, error) {
+	tmpl, parseErr := template.New(name).Option("missingkey=error").Funcs(templateFuncs).Parse(text)
+	if parseErr != nil {
+		return "", fmt.Errorf("unable to parse template for %s: %s", name, parseErr)
+	}
+
+	var sb strings.Builder
+	if execErr := tmpl.Execute(&sb, data); execErr != nil {
+		if isMissingAttributeError(execErr) {
+			return "", nil
+		}
+		return "", fmt.Errorf("unable to render template for %s: %s", name, execErr)
+	}
+
+	return sb.String(), nil
+}
+
+// isMissingAttributeError reports whether a template failed on a map key that
+// is not there, or on a field of a null value
+func isMissingAttributeError(err error) bool {
+	message := err.Error()
+	return strings.Contains(message, "map has no entry for key") || strings.Contains(message, "nil pointer evaluating interface {}")
+}
+
+// renderValues renders every string within a value decoded from YAML, such
+// as an action's extra_vars, returning a rendered copy
+func renderValues(name string, value any, data *TemplateData) (any, error) {
+	switch v := value.(type) {
+	case string:
+		return renderTemplate(name, v, data)
+	case map[string]any:
+		rendered := make(map[string]any, len(v))
+		for key, item := range v {
+			renderedItem, err := renderValues(fmt.Sprintf("%s.%s", name, key), item, data)
+			if err != nil {
+				return nil, err
+			}
+			rendered[key] = renderedItem
+		}
+		return rendered, nil
+	case []any:
+		rendered := make([]any, len(v))
+		for i, item := range v {
+			renderedItem, err := renderValues(fmt.Sprintf("%s[%d]", name, i), item, data)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i] = renderedItem
+		}
+		return rendered, nil
+	default:
+		return value, nil
+	}
+}
+
+func renderExtraVars(extraVars map[string]any, data *TemplateData) (map[string]any, error) {
+	if extraVars == nil {
+		return nil, nil
+	}
+	rendered, err := renderValues("extra_vars", extraVars, data)
+	if err != nil {
+		return nil, err
+	}
+	return rendered.(map[string]any), nil
+}
+
+// template functions take the piped value last, so they can be used as
+// {{ .WorkspaceName | regexReplace "-prod$" "" }}
+
+func templateSlug(s string) string {
+	return strings.Trim(nonSlugCharacters.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+func templateReplace(old string, new string, s string) string {
+	return strings.ReplaceAll(s, old, new)
+}
+
+func templateJoin(sep string, values []any) string {
+	var parts []string
+	for _, value := range values {
+		parts = append(parts, fmt.Sprint(value))
+	}
+	return strings.Join(parts, sep)
+}
+
+func templateRegexReplace(pattern string, replacement string, s string) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+	return re.ReplaceAllString(s, replacement), nil
+}
+
+func templateDefault(def any, value any) any {
+	if value == nil {
+		return def
+	}
+	if s, ok := value.(string); ok && len(s) == 0 {
+		return def
+	}
+	return value
+}
+
+func templateToJSON(value any) (string, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// templateEnv looks up an environment variable by name with the
+// ARTS_VAR_ prefix, so templates cannot read ARTS's own credentials
+func templateEnv(name string) string {
+	return os.Getenv(TemplateEnvPrefix + name)
+}
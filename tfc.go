@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+var tfcToken string
+
+type TFCVariablesResponse struct {
+	Data []struct {
+		ID         string `json:"id"`
+		Attributes struct {
+			Key       string `json:"key"`
+			Value     string `json:"value"`
+			Sensitive bool   `json:"sensitive"`
+			Category  string `json:"category"`
+			HCL       bool   `json:"hcl"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+type TFCRunResponse struct {
+	Data struct {
+		ID         string `json:"id"`
+		Attributes struct {
+			Status    string `json:"status"`
+			IsDestroy bool   `json:"is-destroy"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// TFCPlan is the part of the JSON plan representation that ARTS reads
+type TFCPlan struct {
+	ResourceChanges []TFCResourceChange `json:"resource_changes"`
+}
+
+type TFCResourceChange struct {
+	Address string `json:"address"`
+	Mode    string `json:"mode"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Index   any    `json:"index,omitempty"`
+	Change  struct {
+		Actions []string `json:"actions"`
+		Before  any      `json:"before"`
+		After   any      `json:"after"`
+	} `json:"change"`
+}
+
+type TFCStateVersionOutputsResponse struct {
+	Data []struct {
+		ID         string `json:"id"`
+		Attributes struct {
+			Name      string `json:"name"`
+			Sensitive bool   `json:"sensitive"`
+			Type      any    `json:"type"`
+			Value     any    `json:"value"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// tfcAPIURL returns the TFC/TFE API base URL for a run, taken from its
+// (already validated) callback URL
+func tfcAPIURL(request RunTaskRequest) (string, error) {
+	callback, parseErr := url.Parse(request.TaskResultCallbackURL)
+	if parseErr != nil {
+		return "", parseErr
+	}
+	return fmt.Sprintf("%s://%s", callback.Scheme, callback.Host), nil
+}
+
+// tfcAPIRequest GETs a TFC/TFE API path, authenticating with ARTS_TFC_TOKEN
+// if set, or the Run Task access token otherwise
+func tfcAPIRequest(request RunTaskRequest, path string, out any) error {
+	baseURL, baseErr := tfcAPIURL(request)
+	if baseErr != nil {
+		return baseErr
+	}
+
+	token := tfcToken
+	if len(token) == 0 {
+		token = request.AccessToken
+	}
+
+	return tfcGetRequest(baseURL+path, token, out)
+}
+
+func tfcGetRequest(uri string, token string, out any) error {
+	req, reqErr := http.NewRequest(http.MethodGet, uri, nil)
+	if reqErr != nil {
+		return reqErr
+	}
+
+	req.Header.Set("Content-Type", "application/vnd.api+json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	response, respErr := tfcClient.Do(req)
+	if respErr != nil {
+		return respErr
+	}
+	defer response.Body.Close()
+
+	body, bodyErr := io.ReadAll(response.Body)
+	if bodyErr != nil {
+		return bodyErr
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		var apiErrors APIErrors
+		if json.Unmarshal(body, &apiErrors) == nil && len(apiErrors.Errors) > 0 {
+			return fmt.Errorf("%s returned %s: %s", req.URL.Path, response.Status, apiErrors.Errors[0].Title)
+		}
+		return fmt.Errorf("%s returned %s", req.URL.Path, response.Status)
+	}
+
+	return json.Unmarshal(body, out)
+}
+
+// tfcWorkspaceVariables returns the Workspace's non-sensitive Terraform
+// variables. HCL values are decoded where they are also valid JSON
+func tfcWorkspaceVariables(request RunTaskRequest) (map[string]any, error) {
+	var vars TFCVariablesResponse
+	if err := tfcAPIRequest(request, fmt.Sprintf("/api/v2/workspaces/%s/vars", url.PathEscape(request.WorkspaceID)), &vars); err != nil {
+		return nil, fmt.Errorf("unable to read Workspace variables: %s", err)
+	}
+
+	variables := make(map[string]any)
+	for _, variable := range vars.Data {
+		attributes := variable.Attributes
+		if attributes.Category != "terraform" || attributes.Sensitive {
+			continue
+		}
+
+		var value any = attributes.Value
+		if attributes.HCL {
+			var decoded any
+			if json.Unmarshal([]byte(attributes.Value), &decoded) == nil {
+				value = decoded
+			}
+		}
+		variables[attributes.Key] = value
+	}
+
+	return variables, nil
+}
+
+// tfcStateOutputs returns the non-sensitive outputs of the Workspace's
+// current state version
+func tfcStateOutputs(request RunTaskRequest) (map[string]any, error) {
+	var outputs TFCStateVersionOutputsResponse
+	if err := tfcAPIRequest(request, fmt.Sprintf("/api/v2/workspaces/%s/current-state-version-outputs", url.PathEscape(request.WorkspaceID)), &outputs); err != nil {
+		return nil, fmt.Errorf("unable to read state outputs: %s", err)
+	}
+
+	values := make(map[string]any)
+	for _, output := range outputs.Data {
+		if output.Attributes.Sensitive {
+			continue
+		}
+		values[output.Attributes.Name] = output.Attributes.Value
+	}
+
+	return values, nil
+}
+
+// tfcPlanJSON reads the run's JSON plan using the Run Task access token
+func tfcPlanJSON(request RunTaskRequest) (*TFCPlan, error) {
+	if len(request.PlanJSONAPIURL) == 0 {
+		return nil, fmt.Errorf("the %s Run Task payload has no plan", request.Stage)
+	}
+
+	if urlErr := validateTFCURL(request.PlanJSONAPIURL); urlErr != nil {
+		log.Printf("SECURITY: refused to read plan from %q: %s", request.PlanJSONAPIURL, urlErr)
+		return nil, fmt.Errorf("refused to read the plan from %s", request.PlanJSONAPIURL)
+	}
+
+	var plan TFCPlan
+	if err := tfcGetRequest(request.PlanJSONAPIURL, request.AccessToken, &plan); err != nil {
+		return nil, fmt.Errorf("unable to read the plan: %s", err)
+	}
+
+	return &plan, nil
+}
+
+func tfcRun(request RunTaskRequest) (*TFCRunResponse, error) {
+	var run TFCRunResponse
+	if err := tfcAPIRequest(request, fmt.Sprintf("/api/v2/runs/%s", url.PathEscape(request.RunID)), &run); err != nil {
+		return nil, fmt.Errorf("unable to read run %s: %s", request.RunID, err)
+	}
+	return &run, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var tlsCertFile string
+var tlsKeyFile string
+var tlsClientCAFile string
+var tlsMinVersion string
+var tlsCipherSuites string
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// certificateReloader serves a key pair from disk, picking up a rotated
+// certificate or key on the next handshake after either file changes
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+func newCertificateReloader(certFile string, keyFile string) (*certificateReloader, error) {
+	reloader := &certificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	certMod, keyMod, statErr := reloader.modTimes()
+	if statErr != nil {
+		return nil, statErr
+	}
+
+	if loadErr := reloader.load(certMod, keyMod); loadErr != nil {
+		return nil, loadErr
+	}
+
+	return reloader, nil
+}
+
+func (r *certificateReloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, certErr := os.Stat(r.certFile)
+	if certErr != nil {
+		return time.Time{}, time.Time{}, certErr
+	}
+
+	keyInfo, keyErr := os.Stat(r.keyFile)
+	if keyErr != nil {
+		return time.Time{}, time.Time{}, keyErr
+	}
+
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+func (r *certificateReloader) load(certMod time.Time, keyMod time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.certMod = certMod
+	r.keyMod = keyMod
+
+	return nil
+}
+
+func (r *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certMod, keyMod, statErr := r.modTimes()
+
+	r.mu.RLock()
+	cert := r.cert
+	changed := statErr == nil && (!certMod.Equal(r.certMod) || !keyMod.Equal(r.keyMod))
+	r.mu.RUnlock()
+
+	if statErr != nil {
+		log.Printf("Unable to check TLS certificate for changes, serving the current one: %s", statErr)
+	}
+
+	if changed {
+		// a rotation may replace the certificate and key one after the other, so
+		// keep serving the old pair until the new pair loads cleanly
+		if loadErr := r.load(certMod, keyMod); loadErr != nil {
+			log.Printf("Unable to reload TLS certificate, serving the current one: %s", loadErr)
+		} else {
+			log.Printf("Reloaded TLS certificate from %s", r.certFile)
+			r.mu.RLock()
+			cert = r.cert
+			r.mu.RUnlock()
+		}
+	}
+
+	return cert, nil
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	if len(version) == 0 {
+		return tls.VersionTLS12, nil
+	}
+
+	v, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(version), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version %q", version)
+	}
+
+	return v, nil
+}
+
+func parseCipherSuites(names string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	available := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		available[suite.Name] = suite.ID
+	}
+
+	var suites []uint16
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		id, ok := available[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure TLS cipher suite %q", name)
+		}
+		suites = append(suites, id)
+	}
+
+	return suites, nil
+}
+
+// serverTLSConfig builds the TLS configuration for serving Run Task requests
+// directly, requiring client certificates when a client CA bundle is set
+func serverTLSConfig() (*tls.Config, error) {
+	if len(tlsKeyFile) == 0 {
+		return nil, fmt.Errorf("ARTS_TLS_KEY_FILE must be set alongside ARTS_TLS_CERT_FILE")
+	}
+
+	reloader, reloaderErr := newCertificateReloader(tlsCertFile, tlsKeyFile)
+	if reloaderErr != nil {
+		return nil, reloaderErr
+	}
+
+	minVersion, versionErr := parseTLSVersion(tlsMinVersion)
+	if versionErr != nil {
+		return nil, versionErr
+	}
+
+	cipherSuites, cipherErr := parseCipherSuites(tlsCipherSuites)
+	if cipherErr != nil {
+		return nil, cipherErr
+	}
+
+	config := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+	}
+
+	if len(tlsClientCAFile) > 0 {
+		pem, readErr := os.ReadFile(tlsClientCAFile)
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA bundle %s", tlsClientCAFile)
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
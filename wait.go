@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultWaitInterval = time.Second * 5
+	DefaultWaitTimeout  = time.Minute * 30
+)
+
+var waitInterval = DefaultWaitInterval
+var waitTimeout = DefaultWaitTimeout
+
+// AnsibleUnifiedJob holds the fields common to every kind of controller job,
+// such as jobs, workflow jobs, project updates and inventory updates
+type AnsibleUnifiedJob struct {
+	ID             int     `json:"id"`
+	Type           string  `json:"type"`
+	URL            string  `json:"url"`
+	Name           string  `json:"name"`
+	Status         string  `json:"status"`
+	Failed         bool    `json:"failed"`
+	JobExplanation string  `json:"job_explanation,omitempty"`
+	Elapsed        float64 `json:"elapsed,omitempty"`
+
+	// only jobs have artifacts, from set_stats
+	Artifacts map[string]any `json:"artifacts,omitempty"`
+}
+
+func (j *AnsibleUnifiedJob) finished() bool {
+	switch j.Status {
+	case "successful", "failed", "error", "canceled":
+		return true
+	default:
+		return false
+	}
+}
+
+// result describes how a finished job ended, returning an error unless it
+// was successful
+func (j *AnsibleUnifiedJob) result() error {
+	if j.Status == "successful" {
+		return nil
+	}
+	if len(j.JobExplanation) > 0 {
+		return fmt.Errorf("%s %s: %s", j.Name, j.Status, j.JobExplanation)
+	}
+	return fmt.Errorf("%s %s", j.Name, j.Status)
+}
+
+func parseWaitDuration(name string, value string, def time.Duration) (time.Duration, error) {
+	if len(value) == 0 {
+		return def, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration such as 30s or 10m, not %q", name, value)
+	}
+	return duration, nil
+}
+
+// ansibleWaitForJob polls a controller job until it finishes, or until
+// ARTS_WAIT_TIMEOUT passes. progress, if set, is called each time the job
+// is found to be still running
+func ansibleWaitForJob(path string, progress func(*AnsibleUnifiedJob) error, ansibleAuth *AnsibleAuthResponse) (*AnsibleUnifiedJob, error) {
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		var job AnsibleUnifiedJob
+		if err := ansibleAPIRequest(ansibleAuth, http.MethodGet, path, nil, &job); err != nil {
+			// the controller being briefly unavailable does not end the wait
+			if !isRetryableError(err) || time.Now().After(deadline) {
+				return nil, err
+			}
+			log.Printf("Unable to check %s, trying again: %s", path, err)
+			time.Sleep(waitInterval)
+			continue
+		}
+		if job.finished() {
+			return &job, nil
+		}
+		if time.Now().After(deadline) {
+			return &job, fmt.Errorf("timed out after %s waiting for %s, which is %s", waitTimeout, job.Name, job.Status)
+		}
+		log.Printf("Waiting for %s %d, which is %s", job.Type, job.ID, job.Status)
+		if progress != nil {
+			if err := progress(&job); err != nil {
+				return &job, err
+			}
+		}
+		time.Sleep(waitInterval)
+	}
+}
+
+// waitedRunTaskResponse waits for a launched job, reporting whether it
+// succeeded along with the launch message
+func waitedRunTaskResponse(action ActionConfig, request RunTaskRequest, message string, path string, detailsURL string, ansibleAuth *AnsibleAuthResponse) *RunTaskResponse {
+	running := createRunTaskResponse(Running, fmt.Sprintf("%s, waiting for it to finish", message), detailsURL)
+	tfcRunTaskResponse(running, request.TaskResultCallbackURL, request.AccessToken)
+
+	job, waitErr := ansibleWaitForJob(path, nil, ansibleAuth)
+	if waitErr != nil {
+		return actionRunTaskResponse(action, request, Failed, fmt.Sprintf("%s, but %s", message, waitErr), detailsURL)
+	}
+	outcomes := artifactOutcomes(job, action.Artifacts, detailsURL)
+	if resultErr := job.result(); resultErr != nil {
+		failure := fmt.Sprintf("%s, but %s", message, resultErr)
+		if summary := ansibleFailureSummaryRequest(job, ansibleAuth); len(summary) > 0 {
+			failure = fmt.Sprintf("%s: %s", failure, summary)
+		}
+		return withOutcomes(actionRunTaskResponse(action, request, Failed, failure, detailsURL), outcomes)
+	}
+	return withOutcomes(actionRunTaskResponse(action, request, Passed, fmt.Sprintf("%s, which finished successfully in %.0fs", message, job.Elapsed), detailsURL), outcomes)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	WorkflowApproval = "workflow_approval"
+)
+
+type AnsibleWorkflowNode struct {
+	ID            int    `json:"id"`
+	Identifier    string `json:"identifier"`
+	Job           int    `json:"job,omitempty"`
+	DoNotRun      bool   `json:"do_not_run"`
+	SummaryFields struct {
+		Job struct {
+			ID      int     `json:"id"`
+			Name    string  `json:"name"`
+			Type    string  `json:"type"`
+			Status  string  `json:"status"`
+			Failed  bool    `json:"failed"`
+			Elapsed float64 `json:"elapsed"`
+		} `json:"job"`
+		UnifiedJobTemplate struct {
+			ID             int    `json:"id"`
+			Name           string `json:"name"`
+			UnifiedJobType string `json:"unified_job_type"`
+		} `json:"unified_job_template"`
+	} `json:"summary_fields"`
+}
+
+func (n AnsibleWorkflowNode) name() string {
+	if len(n.SummaryFields.UnifiedJobTemplate.Name) > 0 {
+		return n.SummaryFields.UnifiedJobTemplate.Name
+	}
+	if len(n.SummaryFields.Job.Name) > 0 {
+		return n.SummaryFields.Job.Name
+	}
+	return n.Identifier
+}
+
+// complete reports whether a node has finished, or will never run
+func (n AnsibleWorkflowNode) complete() bool {
+	if n.DoNotRun {
+		return true
+	}
+	job := AnsibleUnifiedJob{Status: n.SummaryFields.Job.Status}
+	return n.Job != 0 && job.finished()
+}
+
+func (n AnsibleWorkflowNode) running() bool {
+	return n.Job != 0 && !n.complete()
+}
+
+// AnsibleWorkflowApproval is the job of a workflow approval node. Denied and
+// timed out approvals both have a failed status
+type AnsibleWorkflowApproval struct {
+	ID                 int        `json:"id"`
+	Name               string     `json:"name"`
+	Status             string     `json:"status"`
+	Timeout            int        `json:"timeout"`
+	TimedOut           bool       `json:"timed_out"`
+	ApprovalExpiration *time.Time `json:"approval_expiration"`
+	SummaryFields      struct {
+		ApprovedOrDeniedBy struct {
+			Username string `json:"username"`
+		} `json:"approved_or_denied_by"`
+	} `json:"summary_fields"`
+}
+
+// waitingMessage describes a pending approval, when it times out, and where
+// to approve it
+func (a *AnsibleWorkflowApproval) waitingMessage() string {
+	timeout := "no timeout"
+	if a.ApprovalExpiration != nil && a.Timeout != 0 {
+		timeout = fmt.Sprintf("times out at %s", a.ApprovalExpiration.UTC().Format(time.RFC3339))
+	}
+	return fmt.Sprintf("waiting for approval of %s (%s): %s", a.Name, timeout, jobDetailsURL(WorkflowApproval, a.ID))
+}
+
+// result returns an error if an approval was denied, timed out or canceled
+func (a *AnsibleWorkflowApproval) result() error {
+	switch {
+	case a.TimedOut:
+		return fmt.Errorf("approval %s timed out after %ds", a.Name, a.Timeout)
+	case a.Status == "failed" && len(a.SummaryFields.ApprovedOrDeniedBy.Username) > 0:
+		return fmt.Errorf("approval %s was denied by %s", a.Name, a.SummaryFields.ApprovedOrDeniedBy.Username)
+	case a.Status == "failed":
+		return fmt.Errorf("approval %s was denied", a.Name)
+	case a.Status == "canceled":
+		return fmt.Errorf("approval %s was canceled", a.Name)
+	default:
+		return nil
+	}
+}
+
+// ansibleWorkflowApprovalsRequest returns the approvals of a workflow's
+// approval nodes that have not been approved
+func ansibleWorkflowApprovalsRequest(nodes []AnsibleWorkflowNode, ansibleAuth *AnsibleAuthResponse) ([]AnsibleWorkflowApproval, error) {
+	var approvals []AnsibleWorkflowApproval
+	for _, node := range nodes {
+		job := node.SummaryFields.Job
+		if node.Job == 0 || job.Type != WorkflowApproval || job.Status == "successful" {
+			continue
+		}
+
+		var approval AnsibleWorkflowApproval
+		if err := ansibleAPIRequest(ansibleAuth, http.MethodGet, fmt.Sprintf("/api/v2/workflow_approvals/%d/", node.Job), nil, &approval); err != nil {
+			return nil, fmt.Errorf("unable to read approval %s: %w", node.name(), err)
+		}
+		approvals = append(approvals, approval)
+	}
+	return approvals, nil
+}
+
+// approvalsResult returns an error for the first approval that was not given
+func approvalsResult(approvals []AnsibleWorkflowApproval) error {
+	for i := range approvals {
+		if err := approvals[i].result(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ansibleWorkflowNodesRequest(workflowJobID int, ansibleAuth *AnsibleAuthResponse) ([]AnsibleWorkflowNode, error) {
+	return ansibleListRequest[AnsibleWorkflowNode](ansibleAuth, fmt.Sprintf("/api/v2/workflow_jobs/%d/workflow_nodes/?page_size=200", workflowJobID))
+}
+
+// jobDetailsURL links to a job's output in the controller UI, by job type
+func jobDetailsURL(jobType string, id int) string {
+	route := map[string]string{
+		"job":               "playbook",
+		"workflow_job":      "workflow",
+		"project_update":    "project",
+		"inventory_update":  "inventory",
+		"ad_hoc_command":    "command",
+		"system_job":        "system",
+		"workflow_approval": "workflow_approval",
+	}[jobType]
+	if len(route) == 0 {
+		return ""
+	}
+	if jobType == "workflow_approval" {
+		return fmt.Sprintf("%s/#/workflow_approvals/%d/details", ansibleHost, id)
+	}
+	return fmt.Sprintf("%s/#/jobs/%s/%d/output", ansibleHost, route, id)
+}
+
+// workflowProgress describes how far through its nodes a workflow is, e.g.
+// "3/7 nodes complete, now running: Patch OS"
+func workflowProgress(nodes []AnsibleWorkflowNode) string {
+	complete := 0
+	var running []string
+	for _, node := range nodes {
+		if node.complete() {
+			complete++
+		} else if node.running() {
+			running = append(running, node.name())
+		}
+	}
+
+	progress := fmt.Sprintf("%d/%d nodes complete", complete, len(nodes))
+	if len(running) > 0 {
+		progress += fmt.Sprintf(", now running: %s", strings.Join(running, ", "))
+	}
+	return progress
+}
+
+// workflowNodeOutcomes returns an outcome for each node that ran, with its
+// job's status and a link to its output
+func workflowNodeOutcomes(nodes []AnsibleWorkflowNode) []RunTaskOutcome {
+	var outcomes []RunTaskOutcome
+	for _, node := range nodes {
+		if node.Job == 0 {
+			continue
+		}
+		job := node.SummaryFields.Job
+		outcome := newRunTaskOutcome(fmt.Sprintf("workflow-node-%d", node.ID), fmt.Sprintf("%s: %s", node.name(), job.Status), jobDetailsURL(job.Type, job.ID))
+		outcome.tag("Status", job.Status, jobStatusLevel(job.Status))
+		if job.Elapsed > 0 {
+			outcome.tag("Elapsed", fmt.Sprintf("%.0fs", job.Elapsed), OutcomeLevelNone)
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	return outcomes
+}
+
+// waitedWorkflowRunTaskResponse waits for a launched workflow, sending its
+// progress through the nodes as running updates, and reports each node's job
+// as an outcome
+func waitedWorkflowRunTaskResponse(action ActionConfig, request RunTaskRequest, message string, workflowJobID int, detailsURL string, ansibleAuth *AnsibleAuthResponse) *RunTaskResponse {
+	running := createRunTaskResponse(Running, fmt.Sprintf("%s, waiting for it to finish", message), detailsURL)
+	tfcRunTaskResponse(running, request.TaskResultCallbackURL, request.AccessToken)
+
+	lastProgress := ""
+	progress := func(job *AnsibleUnifiedJob) error {
+		nodes, nodesErr := ansibleWorkflowNodesRequest(workflowJobID, ansibleAuth)
+		if nodesErr != nil {
+			// progress is best effort, the workflow's own status decides the result
+			return nil
+		}
+		current := workflowProgress(nodes)
+		currentURL := detailsURL
+
+		approvals, approvalsErr := ansibleWorkflowApprovalsRequest(nodes, ansibleAuth)
+		if approvalsErr == nil {
+			// the workflow may carry on down a failure path, but the run should not
+			if err := approvalsResult(approvals); err != nil {
+				return err
+			}
+			for i := range approvals {
+				if approvals[i].Status == "pending" {
+					current = fmt.Sprintf("%s, %s", current, approvals[i].waitingMessage())
+					currentURL = jobDetailsURL(WorkflowApproval, approvals[i].ID)
+				}
+			}
+		}
+
+		if current != lastProgress {
+			lastProgress = current
+			update := createRunTaskResponse(Running, fmt.Sprintf("%s: %s", job.Name, current), currentURL)
+			tfcRunTaskResponse(update, request.TaskResultCallbackURL, request.AccessToken)
+		}
+		return nil
+	}
+
+	job, waitErr := ansibleWaitForJob(fmt.Sprintf("/api/v2/workflow_jobs/%d/", workflowJobID), progress, ansibleAuth)
+
+	var outcomes []RunTaskOutcome
+	failureSummary := ""
+	if nodes, nodesErr := ansibleWorkflowNodesRequest(workflowJobID, ansibleAuth); nodesErr == nil {
+		outcomes = workflowNodeOutcomes(nodes)
+		message = fmt.Sprintf("%s (%s)", message, workflowProgress(nodes))
+		failureSummary = workflowFailureSummary(nodes, ansibleAuth)
+
+		// an approval denied between checks still fails the run
+		if approvals, approvalsErr := ansibleWorkflowApprovalsRequest(nodes, ansibleAuth); approvalsErr == nil && waitErr == nil {
+			waitErr = approvalsResult(approvals)
+		}
+	}
+
+	if waitErr != nil {
+		return withOutcomes(actionRunTaskResponse(action, request, Failed, fmt.Sprintf("%s, but %s", message, waitErr), detailsURL), outcomes)
+	}
+	if resultErr := job.result(); resultErr != nil {
+		failure := fmt.Sprintf("%s, but %s", message, resultErr)
+		if len(failureSummary) > 0 {
+			failure = fmt.Sprintf("%s: %s", failure, failureSummary)
+		}
+		return withOutcomes(actionRunTaskResponse(action, request, Failed, failure, detailsURL), outcomes)
+	}
+	return withOutcomes(actionRunTaskResponse(action, request, Passed, fmt.Sprintf("%s, which finished successfully in %.0fs", message, job.Elapsed), detailsURL), outcomes)
+}
+
+// workflowFailureSummary describes why the failed jobs of a workflow's nodes
+// failed
+func workflowFailureSummary(nodes []AnsibleWorkflowNode, ansibleAuth *AnsibleAuthResponse) string {
+	var summaries []string
+	for _, node := range nodes {
+		nodeJob := node.SummaryFields.Job
+		if node.Job == 0 || (nodeJob.Status != "failed" && nodeJob.Status != "error") {
+			continue
+		}
+		job := &AnsibleUnifiedJob{ID: node.Job, Type: nodeJob.Type, Name: node.name()}
+		if summary := ansibleFailureSummaryRequest(job, ansibleAuth); len(summary) > 0 {
+			summaries = append(summaries, fmt.Sprintf("%s: %s", node.name(), summary))
+		}
+	}
+	return truncate(strings.Join(summaries, " | "), MaxFailureSummary)
+}